@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+// Cache-Control policies. Catalogue reads may be kept by any cache for a short
+// while; anything behind authentication may only be kept by the client, and
+// must be revalidated with its ETag before reuse; tokens are never stored.
+const (
+	cachePublic  = "public, max-age=%d, must-revalidate"
+	cachePrivate = "private, no-cache"
+	cacheNoStore = "no-store"
+)
+
+// CacheControlMiddleware sets the Cache-Control header of the responses in a
+// route group. errorResponse replaces it with no-store, so errors are never
+// kept.
+func (app *application) CacheControlMiddleware(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// publicCachePolicy is cachePublic with the configured max age.
+func (app *application) publicCachePolicy() string {
+	return fmt.Sprintf(cachePublic, int(app.config.cache.maxAge.Seconds()))
+}
+
+// strongETag hashes the values a representation was built from. Equal values
+// always produce byte-identical responses, so the tag is a strong validator.
+func strongETag(parts ...any) string {
+	h := sha256.New()
+	for _, p := range parts {
+		if t, ok := p.(time.Time); ok {
+			p = t.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%v\x00", p)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// bookEditTag is the validator edits to a book are checked against. It only
+// moves with the book's version, so lending out copies or renaming its author
+// doesn't make a client's pending edit fail.
+func bookEditTag(book *data.Book) string {
+	return strongETag("book", book.ID, book.Version)
+}
+
+// bookValidators returns the ETag and Last-Modified time of a single book,
+// which changes with the book itself, its author, any of its genres, its work,
+// the series it's in or how many copies are on the shelf. The tag starts with
+// bookEditTag, so If-Match can still compare only that part.
+func bookValidators(book *data.Book) (string, time.Time) {
+	parts := []any{book.UpdatedAt, book.Author.ID, book.Author.UpdatedAt}
+	modified := book.UpdatedAt
+	if book.Author.UpdatedAt.After(modified) {
+		modified = book.Author.UpdatedAt
+	}
+
+	for _, g := range book.Genres {
+		parts = append(parts, g.ID, g.UpdatedAt)
+		if g.UpdatedAt.After(modified) {
+			modified = g.UpdatedAt
+		}
+	}
+
+	// and the other editions of its work
+	if book.Work != nil {
+		parts = append(parts, book.Work.ID, book.Work.UpdatedAt)
+		if book.Work.UpdatedAt.After(modified) {
+			modified = book.Work.UpdatedAt
+		}
+		for _, e := range book.Work.Editions {
+			parts = append(parts, e.BookID, e.UpdatedAt)
+			if e.UpdatedAt.After(modified) {
+				modified = e.UpdatedAt
+			}
+		}
+	}
+
+	// a book shows its neighbours in each series, which move with the series
+	for _, bs := range book.Series {
+		parts = append(parts, bs.SeriesID, bs.UpdatedAt, bs.Previous, bs.Next)
+		if bs.UpdatedAt.After(modified) {
+			modified = bs.UpdatedAt
+		}
+	}
+
+	// and how many copies are on the shelf
+	if a := book.Availability; a != nil {
+		parts = append(parts, a.Copies, a.Available, a.NextDueAt, a.UpdatedAt)
+		if a.UpdatedAt.After(modified) {
+			modified = a.UpdatedAt
+		}
+	}
+
+	etag := strings.TrimSuffix(bookEditTag(book), `"`) + "-" + strings.TrimPrefix(strongETag(parts...), `"`)
+	return etag, modified
+}
+
+// userValidators returns the ETag and Last-Modified time of a single user.
+func userValidators(user *data.User) (string, time.Time) {
+	return strongETag("user", user.ID, user.Version, user.UpdatedAt), user.UpdatedAt
+}
+
+// notModified sets the ETag and Last-Modified headers and, when the request's
+// If-None-Match or If-Modified-Since show the client already has this
+// version, answers 304 and returns true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match wins when both are sent (RFC 9110, section 13.2.2)
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison the header calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch checks an edit's If-Match header against etag, the current tag of
+// the record being changed. A tag that extends etag, such as a book's full
+// ETag, matches too. When the header is sent and doesn't list etag, the
+// edit fails with errPreconditionFailed and current, the record as it is now.
+func ifMatch(r *http.Request, etag string, current any) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	// If-Match uses the strong comparison, so weak tags never match
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag || strings.HasPrefix(candidate, strings.TrimSuffix(etag, `"`)+"-") {
+			return nil
+		}
+	}
+	return &staleEdit{err: errPreconditionFailed, current: current}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+func TestBookIfMatch(t *testing.T) {
+	book := &data.Book{ID: 7, Version: 3}
+	shelf := &data.Availability{Copies: 2, Available: 2}
+	book.Availability = shelf
+	etag, _ := bookValidators(book)
+
+	// lending a copy changes the ETag but not what an edit is checked against
+	lent := *book
+	lent.Availability = &data.Availability{Copies: 2, Available: 1}
+	lentTag, _ := bookValidators(&lent)
+	if lentTag == etag {
+		t.Fatal("the ETag doesn't change with availability")
+	}
+
+	edited := *book
+	edited.Version++
+
+	tests := []struct {
+		name   string
+		header string
+		book   *data.Book
+		ok     bool
+	}{
+		{"no header", "", book, true},
+		{"current tag", etag, book, true},
+		{"tag from before a loan", etag, &lent, true},
+		{"edit tag", bookEditTag(book), book, true},
+		{"any", "*", book, true},
+		{"one of several", `"other", ` + etag, book, true},
+		{"outdated version", etag, &edited, false},
+		{"weak tag", "W/" + etag, book, false},
+		{"other book", etag, &data.Book{ID: 8, Version: 3}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/v1/books/7", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			err := ifMatch(r, bookEditTag(tt.book), tt.book)
+			if (err == nil) != tt.ok {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
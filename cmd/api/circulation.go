@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Circulation: the physical copies of a book, and lending them to users. A
+// loan is due app.config.loans.period after checkout, and each renewal moves
+// the due date to a period from then.
+
+// copyInput is the editable fields of a copy. Copies are created available;
+// on_loan is only set and cleared by checkouts and returns.
+type copyInput struct {
+	Barcode   string `json:"barcode" validate:"required,max=64"`
+	Location  string `json:"location" validate:"max=255"`
+	Condition string `json:"condition" validate:"oneof=new good fair poor damaged"`
+	Status    string `json:"status" validate:"oneof=available on_loan lost withdrawn"`
+}
+
+// checkoutInput names the copy to lend, by id or barcode, and who to lend it
+// to, the requester unless given.
+type checkoutInput struct {
+	CopyID  int    `json:"copy_id"`
+	Barcode string `json:"barcode"`
+	UserID  int    `json:"user_id"`
+}
+
+// loanPolicy returns the configured limits on loans.
+func (app *application) loanPolicy() data.LoanPolicy {
+	return data.LoanPolicy{
+		Period:      app.config.loans.period,
+		MaxRenewals: app.config.loans.maxRenewals,
+		MaxPerUser:  app.config.loans.maxPerUser,
+		MaxBalance:  app.config.fines.blockThreshold,
+	}
+}
+
+func (app *application) ListCopies(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// trashed books have no copies to show
+	_, err = app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	copies, err := app.models.Copy.ForBook(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"copies": copies},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) CreateCopy(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input, err := app.readCopyInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	if input.Status != "" && input.Status != data.CopyAvailable {
+		app.errorResponse(w, r, data.FieldErrors{"status": {"must be available, or left out, for a new copy"}})
+		return
+	}
+
+	_, err = app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	copy := data.Copy{BookID: bookID, Barcode: input.Barcode, Location: input.Location, Condition: input.Condition}
+	if copy.Condition == "" {
+		copy.Condition = "good"
+	}
+
+	id, err := app.models.Copy.Insert(r.Context(), copy)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeCopy(w, r, http.StatusCreated, "copy created", id)
+}
+
+func (app *application) ShowCopy(w http.ResponseWriter, r *http.Request) {
+	copyID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeCopy(w, r, http.StatusOK, "success", copyID)
+}
+
+// UpdateCopy changes a copy. An empty condition or status keeps the current
+// one; copies leave circulation by being marked lost or withdrawn rather than
+// deleted, so their loans are kept.
+func (app *application) UpdateCopy(w http.ResponseWriter, r *http.Request) {
+	copyID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input, err := app.readCopyInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	copy, err := app.models.Copy.GetOne(r.Context(), copyID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	copy.Barcode = input.Barcode
+	copy.Location = input.Location
+	if input.Condition != "" {
+		copy.Condition = input.Condition
+	}
+	if input.Status != "" {
+		copy.Status = input.Status
+	}
+
+	err = copy.Update(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// a copy back in circulation, say one that was lost, may be waited for
+	if copy.Status == data.CopyAvailable {
+		err = app.allocateCopy(r.Context(), copyID)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	app.writeCopy(w, r, http.StatusOK, "Changes saved", copyID)
+}
+
+// ListLoans lists loans, most recent first, optionally only those of one user
+// (?user_id=) or in one state (?status=open|overdue|returned). Only staff see
+// other users' loans.
+func (app *application) ListLoans(w http.ResponseWriter, r *http.Request) {
+	var filter data.LoanFilter
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil || id < 1 {
+			app.errorResponse(w, r, badRequest("invalid_user_id", "user_id must be a positive integer", err))
+			return
+		}
+		filter.UserID = id
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !slices.Contains(data.LoanStatuses, status) {
+			app.errorResponse(w, r, badRequest("invalid_status", "status must be one of "+strings.Join(data.LoanStatuses, ", "), nil))
+			return
+		}
+		filter.Status = status
+	}
+
+	var err error
+	filter.UserID, err = app.listFor(r, filter.UserID, "list loans")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	loans, err := app.models.Loan.All(r.Context(), filter)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"loans": loans},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// ShowLoan shows a loan of the requester, or of anyone when they're staff.
+func (app *application) ShowLoan(w http.ResponseWriter, r *http.Request) {
+	loanID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	loan, err := app.models.Loan.GetOne(r.Context(), loanID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.showFor(r, loan.UserID, "loan")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeLoan(w, r, http.StatusOK, "success", loanID)
+}
+
+// Checkout lends a copy, named by copy_id or barcode, to a user; only staff
+// can lend to anyone but themselves.
+func (app *application) Checkout(w http.ResponseWriter, r *http.Request) {
+	var input checkoutInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CopyID != 0 || input.Barcode != "", "copy_id", "must be provided, or a barcode")
+	v.Check(input.CopyID == 0 || input.Barcode == "", "barcode", "must not be provided along with copy_id")
+	v.Check(input.CopyID >= 0, "copy_id", "must be positive")
+	v.Check(input.UserID >= 0, "user_id", "must be positive")
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	if input.Barcode != "" {
+		copy, err := app.models.Copy.GetByBarcode(r.Context(), input.Barcode)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		input.CopyID = copy.ID
+	}
+	if input.UserID == 0 {
+		if user := app.contextGetUser(r); user != nil {
+			input.UserID = user.ID
+		}
+	}
+
+	err = app.actFor(r, input.UserID, "check out copies")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.models.Loan.Checkout(r.Context(), input.CopyID, input.UserID, app.loanPolicy())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeLoan(w, r, http.StatusCreated, "copy checked out", id)
+}
+
+// ReturnLoan checks a copy back in. It's routed for staff only, as returns are
+// taken at the desk.
+func (app *application) ReturnLoan(w http.ResponseWriter, r *http.Request) {
+	loanID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	err = app.models.Loan.Return(ctx, loanID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// a late return settles the fine at what it cost up to now
+	_, err = app.models.Fine.Accrue(ctx, app.finePolicy(), loanID)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	// the copy goes to the first in line, if anyone is waiting for it
+	loan, err := app.models.Loan.GetOne(ctx, loanID)
+	if err == nil {
+		err = app.allocateCopy(ctx, loan.CopyID)
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.writeLoan(w, r, http.StatusOK, "copy returned", loanID)
+}
+
+// RenewLoan renews a loan of the requester, or of anyone when they're staff.
+func (app *application) RenewLoan(w http.ResponseWriter, r *http.Request) {
+	loanID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	loan, err := app.models.Loan.GetOne(r.Context(), loanID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.actFor(r, loan.UserID, "renew loans")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Loan.Renew(r.Context(), loanID, app.loanPolicy())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeLoan(w, r, http.StatusOK, "loan renewed", loanID)
+}
+
+// readCopyInput reads and validates the fields of a copy.
+func (app *application) readCopyInput(w http.ResponseWriter, r *http.Request) (copyInput, error) {
+	var input copyInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return input, err
+	}
+
+	input.Barcode = strings.TrimSpace(input.Barcode)
+
+	v := validator.New()
+	v.Struct(&input)
+	if !v.Valid() {
+		return input, data.FieldErrors(v.Errors)
+	}
+
+	return input, nil
+}
+
+// writeCopy responds with the copy with the given id.
+func (app *application) writeCopy(w http.ResponseWriter, r *http.Request, status int, message string, id int) {
+	copy, err := app.models.Copy.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var headers []http.Header
+	if status == http.StatusCreated {
+		h := make(http.Header)
+		h.Set("Location", fmt.Sprintf("/v1/copies/%d", id))
+		headers = append(headers, h)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    envelope{"copy": copy},
+	}
+
+	err = app.writeJSON(w, status, payload, headers...)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// writeLoan responds with the loan with the given id.
+func (app *application) writeLoan(w http.ResponseWriter, r *http.Request, status int, message string, id int) {
+	loan, err := app.models.Loan.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var headers []http.Header
+	if status == http.StatusCreated {
+		h := make(http.Header)
+		h.Set("Location", fmt.Sprintf("/v1/loans/%d", id))
+		headers = append(headers, h)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    envelope{"loan": loan},
+	}
+
+	err = app.writeJSON(w, status, payload, headers...)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	port int // what port do we want the web server to listen on
+	env  string
+	dsn  string
+
+	server struct {
+		readTimeout       time.Duration // whole request, including the body
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration // keep-alive connections
+		maxHeaderBytes    int
+		shutdownTimeout   time.Duration // how long in-flight requests get to finish
+	}
+
+	db struct {
+		maxOpenConns    int
+		maxIdleConns    int
+		connMaxLifetime time.Duration
+		readTimeout     time.Duration // each query
+		writeTimeout    time.Duration // each insert, update or delete
+	}
+
+	cors struct {
+		allowedOrigins   stringList
+		allowCredentials bool
+		maxAge           int // seconds browsers may cache preflight responses
+	}
+
+	tokens struct {
+		ttl time.Duration
+	}
+
+	metrics struct {
+		port int // 0 turns the metrics listener off
+	}
+
+	cache struct {
+		maxAge time.Duration // how long shared caches may keep catalogue reads
+	}
+
+	ratelimit struct {
+		enabled        bool
+		store          string // memory or postgres
+		trustForwarded bool   // key anonymous clients by the last X-Forwarded-For entry
+		auth           rateLimit
+		public         rateLimit
+		user           rateLimit
+	}
+
+	trash struct {
+		retention     time.Duration // how long deleted records can be restored
+		purgeInterval time.Duration // how often expired records are deleted for good
+	}
+
+	loans struct {
+		period      time.Duration // from checkout, or renewal, to the due date
+		maxRenewals int           // times a loan can be renewed
+		maxPerUser  int           // copies a user can have out at once
+	}
+
+	fines struct {
+		perDay         int           // cents charged for each day a loan is late
+		gracePeriod    time.Duration // lateness that isn't charged
+		maxPerLoan     int           // cents
+		blockThreshold int           // cents owed above which checkouts are refused
+		interval       time.Duration // how often overdue loans are flagged and charged
+	}
+
+	holds struct {
+		pickupWindow   time.Duration // how long a returned copy is kept for the next in line
+		expiryInterval time.Duration // how often holds not picked up are expired
+	}
+
+	mail struct {
+		smtp   string // smtp://[user:password@]host:port, empty to not send mail
+		sender string
+	}
+
+	tracing struct {
+		exporter     string // none, stdout or otlp
+		otlpEndpoint string // host:port of an OTLP/HTTP collector
+		sampleRatio  float64
+	}
+}
+
+// rateLimit is the token bucket given to each client of a route group.
+type rateLimit struct {
+	perMinute int
+	burst     int
+}
+
+// stringList is a comma separated flag value.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l *stringList) Get() any { return []string(*l) }
+
+// configLoader knows every setting by its key in the config file, e.g.
+// "db.max_open_conns". The same setting is the flag -db-max-open-conns and the
+// environment variable GOBOOK_DB_MAX_OPEN_CONNS.
+type configLoader struct {
+	cfg     *config
+	fs      *flag.FlagSet
+	keys    map[string]string // flag name to file key
+	secrets map[string]bool   // file keys never printed in full
+
+	path      string // config file
+	printOnly bool   // print the configuration instead of serving
+}
+
+// envAliases are the environment variables read before configuration had a
+// GOBOOK_ prefix. They're still honoured, below their prefixed equivalents.
+var envAliases = map[string]string{
+	"dsn": "DSN",
+	"env": "ENV",
+}
+
+func newConfigLoader(cfg *config) *configLoader {
+	l := &configLoader{
+		cfg:     cfg,
+		fs:      flag.NewFlagSet("gobook", flag.ContinueOnError),
+		keys:    make(map[string]string),
+		secrets: map[string]bool{"dsn": true, "mail.smtp": true},
+	}
+
+	l.intVar(&cfg.port, "port", 8081, "port the API listens on")
+	l.stringVar(&cfg.env, "env", "development", "environment (development|production)")
+	l.stringVar(&cfg.dsn, "dsn", "", "Postgres connection string")
+
+	l.durationVar(&cfg.server.readTimeout, "server.read_timeout", 10*time.Second, "maximum duration for reading a whole request")
+	l.durationVar(&cfg.server.readHeaderTimeout, "server.read_header_timeout", 5*time.Second, "maximum duration for reading request headers")
+	l.durationVar(&cfg.server.writeTimeout, "server.write_timeout", 30*time.Second, "maximum duration before timing out writes of a response")
+	l.durationVar(&cfg.server.idleTimeout, "server.idle_timeout", time.Minute, "how long idle keep-alive connections are kept open")
+	l.intVar(&cfg.server.maxHeaderBytes, "server.max_header_bytes", 1<<20, "maximum size of request headers in bytes")
+	l.durationVar(&cfg.server.shutdownTimeout, "server.shutdown_timeout", 20*time.Second, "how long in-flight requests get to finish on shutdown")
+
+	l.intVar(&cfg.db.maxOpenConns, "db.max_open_conns", 5, "maximum open database connections")
+	l.intVar(&cfg.db.maxIdleConns, "db.max_idle_conns", 5, "maximum idle database connections")
+	l.durationVar(&cfg.db.connMaxLifetime, "db.conn_max_lifetime", 5*time.Minute, "maximum lifetime of a database connection")
+	l.durationVar(&cfg.db.readTimeout, "db.read_timeout", 3*time.Second, "maximum duration of a database query")
+	l.durationVar(&cfg.db.writeTimeout, "db.write_timeout", 5*time.Second, "maximum duration of a database insert, update or delete")
+
+	cfg.cors.allowedOrigins = stringList{"https://*", "http://*"}
+	l.listVar(&cfg.cors.allowedOrigins, "cors.allowed_origins", "comma separated origins allowed to make cross-site requests")
+	l.boolVar(&cfg.cors.allowCredentials, "cors.allow_credentials", true, "allow cross-site requests with credentials")
+	l.intVar(&cfg.cors.maxAge, "cors.max_age", 300, "seconds browsers may cache preflight responses")
+
+	l.durationVar(&cfg.tokens.ttl, "tokens.ttl", 24*time.Hour, "lifetime of authentication tokens")
+
+	l.intVar(&cfg.metrics.port, "metrics.port", 9091, "port serving Prometheus metrics on /metrics, 0 to disable")
+
+	l.durationVar(&cfg.cache.maxAge, "cache.max_age", time.Minute, "how long clients and shared caches may reuse catalogue reads before revalidating")
+
+	l.boolVar(&cfg.ratelimit.enabled, "ratelimit.enabled", true, "throttle clients")
+	l.stringVar(&cfg.ratelimit.store, "ratelimit.store", "memory", "where request counts are kept (memory|postgres), postgres shares them between instances")
+	l.boolVar(&cfg.ratelimit.trustForwarded, "ratelimit.trust_forwarded", false, "identify anonymous clients by the address the proxy appends to X-Forwarded-For, only behind one")
+	l.rateLimitVar(&cfg.ratelimit.auth, "ratelimit.auth", 10, 5, "sign in, sign up and token validation")
+	l.rateLimitVar(&cfg.ratelimit.public, "ratelimit.public", 120, 60, "anonymous catalogue reads")
+	l.rateLimitVar(&cfg.ratelimit.user, "ratelimit.user", 300, 100, "authenticated requests, per user")
+
+	l.durationVar(&cfg.trash.retention, "trash.retention", 30*24*time.Hour, "how long deleted books, authors and users can be restored before they're purged")
+	l.durationVar(&cfg.trash.purgeInterval, "trash.purge_interval", time.Hour, "how often the trash is checked for records to purge")
+
+	l.durationVar(&cfg.loans.period, "loans.period", 21*24*time.Hour, "how long copies are lent for, from checkout or renewal")
+	l.intVar(&cfg.loans.maxRenewals, "loans.max_renewals", 2, "how many times a loan can be renewed")
+	l.intVar(&cfg.loans.maxPerUser, "loans.max_per_user", 5, "how many copies a user can have out at once")
+
+	l.intVar(&cfg.fines.perDay, "fines.per_day", 25, "cents charged for each day a loan is overdue, 0 to charge nothing")
+	l.durationVar(&cfg.fines.gracePeriod, "fines.grace_period", 24*time.Hour, "how late a loan can be before it's charged for")
+	l.intVar(&cfg.fines.maxPerLoan, "fines.max_per_loan", 1000, "most cents charged for one overdue loan")
+	l.intVar(&cfg.fines.blockThreshold, "fines.block_threshold", 500, "cents a user can owe and still check out copies")
+	l.durationVar(&cfg.fines.interval, "fines.interval", time.Hour, "how often overdue loans are flagged and their fines brought up to date")
+
+	l.durationVar(&cfg.holds.pickupWindow, "holds.pickup_window", 72*time.Hour, "how long a copy is kept for a hold before it goes to the next in line")
+	l.durationVar(&cfg.holds.expiryInterval, "holds.expiry_interval", 15*time.Minute, "how often holds that weren't picked up are expired")
+
+	l.stringVar(&cfg.mail.smtp, "mail.smtp", "", "SMTP server as smtp://[user:password@]host:port, empty to not send mail")
+	l.stringVar(&cfg.mail.sender, "mail.sender", "GoBook <no-reply@gobook.local>", "From address of the mail the API sends")
+
+	l.stringVar(&cfg.tracing.exporter, "tracing.exporter", "none", "where traces are sent (none|stdout|otlp)")
+	l.stringVar(&cfg.tracing.otlpEndpoint, "tracing.otlp_endpoint", "", "OTLP/HTTP collector host:port, defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318")
+	l.floatVar(&cfg.tracing.sampleRatio, "tracing.sample_ratio", 1, "fraction of new traces sampled")
+
+	l.fs.StringVar(&l.path, "config", os.Getenv("GOBOOK_CONFIG"), "path to a YAML config file")
+	l.fs.BoolVar(&l.printOnly, "print-config", false, "print the resolved configuration, with secrets redacted, and exit")
+
+	return l
+}
+
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+func envName(key string) string {
+	return "GOBOOK_" + strings.ToUpper(strings.NewReplacer(".", "_").Replace(key))
+}
+
+func (l *configLoader) register(key string) string {
+	name := flagName(key)
+	l.keys[name] = key
+	return name
+}
+
+func (l *configLoader) intVar(p *int, key string, value int, usage string) {
+	l.fs.IntVar(p, l.register(key), value, usage)
+}
+
+func (l *configLoader) stringVar(p *string, key, value, usage string) {
+	l.fs.StringVar(p, l.register(key), value, usage)
+}
+
+func (l *configLoader) boolVar(p *bool, key string, value bool, usage string) {
+	l.fs.BoolVar(p, l.register(key), value, usage)
+}
+
+func (l *configLoader) floatVar(p *float64, key string, value float64, usage string) {
+	l.fs.Float64Var(p, l.register(key), value, usage)
+}
+
+func (l *configLoader) durationVar(p *time.Duration, key string, value time.Duration, usage string) {
+	l.fs.DurationVar(p, l.register(key), value, usage)
+}
+
+func (l *configLoader) rateLimitVar(p *rateLimit, key string, perMinute, burst int, what string) {
+	l.intVar(&p.perMinute, key+".per_minute", perMinute, "average requests a minute allowed for "+what)
+	l.intVar(&p.burst, key+".burst", burst, "requests allowed at once for "+what)
+}
+
+func (l *configLoader) listVar(p *stringList, key, usage string) {
+	l.fs.Var(p, l.register(key), usage)
+}
+
+// load builds the configuration from, in increasing order of precedence,
+// built-in defaults, the YAML file named by -config or GOBOOK_CONFIG,
+// environment variables and command line flags, and validates the result.
+func (l *configLoader) load(args []string) error {
+	err := l.fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// flags win over everything, so remember them and apply them again last
+	explicit := make(map[string]string)
+	l.fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if l.path != "" {
+		err = l.loadFile(l.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, key := range l.keys {
+		value, ok := os.LookupEnv(envName(key))
+		if !ok && envAliases[key] != "" {
+			value, ok = os.LookupEnv(envAliases[key])
+		}
+		if ok {
+			if err := l.fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %w", envName(key), err)
+			}
+		}
+	}
+
+	for name, value := range explicit {
+		_ = l.fs.Set(name, value)
+	}
+
+	return l.cfg.validate()
+}
+
+// loadFile applies the settings found in the YAML file at path.
+func (l *configLoader) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var tree map[string]any
+	err = yaml.Unmarshal(raw, &tree)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	flatten("", tree, settings)
+
+	for key, value := range settings {
+		name := flagName(key)
+		if _, ok := l.keys[name]; !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if err := l.fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// flatten turns nested YAML mappings into dotted keys with string values.
+func flatten(prefix string, tree map[string]any, out map[string]string) {
+	for k, v := range tree {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			flatten(key, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func (cfg config) validate() error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port < 65536, "port", "must be between 1 and 65535")
+	v.Check(cfg.env == "development" || cfg.env == "production", "env", "must be development or production")
+	v.Check(cfg.dsn != "", "dsn", "must be provided")
+
+	v.Check(cfg.server.readTimeout > 0, "server.read_timeout", "must be positive")
+	v.Check(cfg.server.readHeaderTimeout > 0, "server.read_header_timeout", "must be positive")
+	v.Check(cfg.server.writeTimeout > 0, "server.write_timeout", "must be positive")
+	v.Check(cfg.server.idleTimeout > 0, "server.idle_timeout", "must be positive")
+	v.Check(cfg.server.maxHeaderBytes >= 4096, "server.max_header_bytes", "must be at least 4096")
+	v.Check(cfg.server.shutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	v.Check(cfg.db.maxOpenConns > 0, "db.max_open_conns", "must be positive")
+	v.Check(cfg.db.maxIdleConns >= 0 && cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db.max_idle_conns", "must be between 0 and db.max_open_conns")
+	v.Check(cfg.db.connMaxLifetime > 0, "db.conn_max_lifetime", "must be positive")
+	v.Check(cfg.db.readTimeout > 0, "db.read_timeout", "must be positive")
+	v.Check(cfg.db.writeTimeout > 0, "db.write_timeout", "must be positive")
+
+	v.Check(len(cfg.cors.allowedOrigins) > 0, "cors.allowed_origins", "must list at least one origin")
+	v.Check(cfg.cors.maxAge >= 0, "cors.max_age", "must not be negative")
+
+	v.Check(cfg.tokens.ttl >= time.Minute, "tokens.ttl", "must be at least 1m")
+
+	v.Check(cfg.metrics.port >= 0 && cfg.metrics.port < 65536, "metrics.port", "must be between 0 and 65535")
+	v.Check(cfg.metrics.port == 0 || cfg.metrics.port != cfg.port, "metrics.port", "must differ from port")
+
+	v.Check(cfg.cache.maxAge >= 0, "cache.max_age", "must not be negative")
+
+	v.Check(cfg.ratelimit.store == "memory" || cfg.ratelimit.store == "postgres", "ratelimit.store", "must be memory or postgres")
+	for key, limit := range map[string]rateLimit{"auth": cfg.ratelimit.auth, "public": cfg.ratelimit.public, "user": cfg.ratelimit.user} {
+		v.Check(limit.perMinute > 0, "ratelimit."+key+".per_minute", "must be positive")
+		v.Check(limit.burst > 0, "ratelimit."+key+".burst", "must be positive")
+	}
+
+	v.Check(cfg.trash.retention > 0, "trash.retention", "must be positive")
+	v.Check(cfg.trash.purgeInterval >= time.Minute, "trash.purge_interval", "must be at least 1m")
+
+	v.Check(cfg.loans.period >= time.Hour, "loans.period", "must be at least 1h")
+	v.Check(cfg.loans.maxRenewals >= 0, "loans.max_renewals", "must not be negative")
+	v.Check(cfg.loans.maxPerUser > 0, "loans.max_per_user", "must be positive")
+
+	v.Check(cfg.fines.perDay >= 0, "fines.per_day", "must not be negative")
+	v.Check(cfg.fines.gracePeriod >= 0, "fines.grace_period", "must not be negative")
+	v.Check(cfg.fines.maxPerLoan >= cfg.fines.perDay, "fines.max_per_loan", "must be at least fines.per_day")
+	v.Check(cfg.fines.blockThreshold >= 0, "fines.block_threshold", "must not be negative")
+	v.Check(cfg.fines.interval >= time.Minute, "fines.interval", "must be at least 1m")
+
+	v.Check(cfg.holds.pickupWindow >= time.Hour, "holds.pickup_window", "must be at least 1h")
+	v.Check(cfg.holds.expiryInterval >= time.Minute, "holds.expiry_interval", "must be at least 1m")
+
+	if cfg.mail.smtp != "" {
+		u, err := url.Parse(cfg.mail.smtp)
+		v.Check(err == nil && u.Scheme == "smtp" && u.Hostname() != "", "mail.smtp", "must look like smtp://host:port")
+	}
+	_, err := mail.ParseAddress(cfg.mail.sender)
+	v.Check(err == nil, "mail.sender", "must be an email address, optionally with a name")
+
+	v.Check(cfg.tracing.exporter == "none" || cfg.tracing.exporter == "stdout" || cfg.tracing.exporter == "otlp", "tracing.exporter", "must be none, stdout or otlp")
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		problems[i] = key + " " + strings.Join(v.Errors[key], ", ")
+	}
+
+	return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+}
+
+// print writes the resolved configuration as YAML, in the same shape the
+// config file takes, with secrets redacted.
+func (l *configLoader) print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+
+	return enc.Encode(l.summary())
+}
+
+// summary returns the resolved configuration nested as in the config file,
+// with secrets redacted.
+func (l *configLoader) summary() map[string]any {
+	tree := make(map[string]any)
+	l.fs.VisitAll(func(f *flag.Flag) {
+		key, ok := l.keys[f.Name]
+		if !ok {
+			return
+		}
+
+		value := f.Value.(flag.Getter).Get()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		if l.secrets[key] {
+			value = redact(f.Value.String())
+		}
+
+		node := tree
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				node[part] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = value
+	})
+
+	return tree
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// redact hides the password in a key=value or URL style connection string.
+func redact(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}REDACTED")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed docs
+var docsFS embed.FS
+
+// undocumentedPrefixes are routes that deliberately have no entry in the spec.
+var undocumentedPrefixes = []string{"/static/"}
+
+func (app *application) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	spec, err := docsFS.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(spec)
+}
+
+// Docs serves a page rendering the spec. The spec is written into the page and
+// rendered by the page's own script, so the docs work offline and without any
+// third party.
+func (app *application) Docs(w http.ResponseWriter, r *http.Request) {
+	page, err := template.ParseFS(docsFS, "docs/index.html")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	raw, err := docsFS.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var spec any
+	err = yaml.Unmarshal(raw, &spec)
+	if err != nil {
+		app.errorResponse(w, r, fmt.Errorf("parsing openapi.yaml: %w", err))
+		return
+	}
+
+	var buf bytes.Buffer
+	err = page.Execute(&buf, spec)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// undocumentedRoutes lists the "METHOD /pattern" of every route registered on
+// routes that has no matching operation in the OpenAPI document.
+func undocumentedRoutes(routes chi.Routes) ([]string, error) {
+	raw, err := docsFS.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	err = yaml.Unmarshal(raw, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing openapi.yaml: %w", err)
+	}
+
+	var missing []string
+	err = chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		for _, prefix := range undocumentedPrefixes {
+			if strings.HasPrefix(route, prefix) {
+				return nil
+			}
+		}
+
+		if _, ok := spec.Paths[route][strings.ToLower(method)]; !ok {
+			missing = append(missing, method+" "+route)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(missing)
+	return missing, nil
+}
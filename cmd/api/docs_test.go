@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TestRoutesDocumented fails when a route is added without an operation in
+// docs/openapi.yaml.
+func TestRoutesDocumented(t *testing.T) {
+	app := &application{}
+
+	missing, err := undocumentedRoutes(app.routes().(chi.Routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range missing {
+		t.Errorf("%s is missing from docs/openapi.yaml", route)
+	}
+}
+
+func TestUndocumentedRoutes(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request) {}
+
+	mux := chi.NewRouter()
+	mux.Get("/v1/books", noop)
+	mux.Get("/v1/not-in-the-spec", noop)
+	mux.Delete("/v1/books", noop)
+	mux.Get("/static/*", noop)
+
+	missing, err := undocumentedRoutes(mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"DELETE /v1/books", "GET /v1/not-in-the-spec"}
+	if !slices.Equal(missing, want) {
+		t.Errorf("got %q, want %q", missing, want)
+	}
+}
+
+func TestDocs(t *testing.T) {
+	app := &application{}
+
+	rr := httptest.NewRecorder()
+	app.Docs(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rr.Code)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, "<script src=") || strings.Contains(body, "<link") {
+		t.Error("the docs page loads assets from elsewhere")
+	}
+	if !strings.Contains(body, `"openapi":"3.`) {
+		t.Error("the spec isn't written into the docs page")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+// errBadRequest is the kind used for requests the handlers can't make sense of,
+// such as malformed JSON or non-numeric ids. It never comes from the data layer.
+var errBadRequest = errors.New("bad request")
+
+// errPreconditionFailed is the kind used when If-Match names a version of a
+// record that is no longer current.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// errPreconditionRequired is the kind used when an edit names neither the
+// version it changes nor an If-Match tag, so it could overwrite anything.
+var errPreconditionRequired = errors.New("precondition required")
+
+// problem is an RFC 7807 problem details body. Error and Message mirror
+// jsonResponse so clients written against the older error shape keep working.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	Error    bool   `json:"error"`
+	Message  string `json:"message"`
+
+	Errors map[string][]string `json:"errors,omitempty"`
+
+	// Current is the record as it is now, sent with edit conflicts
+	Current any `json:"current,omitempty"`
+}
+
+// errorKinds maps each error kind to the status code and fallback code used
+// when the error carries no more specific one.
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   string
+	detail string // defaults to the kind's own message
+}{
+	{errBadRequest, http.StatusBadRequest, "bad_request", ""},
+	{data.ErrNotFound, http.StatusNotFound, "not_found", ""},
+	{data.ErrConflict, http.StatusConflict, "conflict", ""},
+	{data.ErrValidation, http.StatusUnprocessableEntity, "validation_failed", ""},
+	{data.ErrUnauthorized, http.StatusUnauthorized, "unauthorized", ""},
+	{data.ErrForbidden, http.StatusForbidden, "forbidden", ""},
+	{errPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed", "the record has changed since the version named in If-Match"},
+	{errPreconditionRequired, http.StatusPreconditionRequired, "precondition_required", "send the version being changed in the body, or its ETag in If-Match"},
+	{errRateLimited, http.StatusTooManyRequests, "rate_limited", "too many requests, try again later"},
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, "timeout", "the database took too long to respond, try again later"},
+}
+
+// staleEdit is an edit based on an outdated copy of a record, together with the
+// record as it is now so the client can redo its change on top of it.
+type staleEdit struct {
+	err     error
+	current any
+}
+
+func (e *staleEdit) Error() string { return e.err.Error() }
+
+func (e *staleEdit) Unwrap() error { return e.err }
+
+// withCurrent attaches the record as it is now, fetched by load, to an edit
+// conflict. Other errors are returned as they are.
+func withCurrent(err error, load func() (any, error)) error {
+	var dataErr *data.Error
+	if !errors.As(err, &dataErr) || dataErr.Code != "edit_conflict" {
+		return err
+	}
+
+	current, loadErr := load()
+	if loadErr != nil {
+		return err
+	}
+	return &staleEdit{err: err, current: current}
+}
+
+// badRequest wraps err, which may be nil, as a client error with a stable code.
+func badRequest(code, message string, err error) error {
+	return &data.Error{Kind: errBadRequest, Code: code, Message: message, Err: err}
+}
+
+// errorResponse writes err as a problem+json response. Classified errors get
+// their matching status code; anything else is logged and reported as a
+// generic 500 so internal details never reach the client.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	p := problem{
+		Type:     "about:blank",
+		Status:   http.StatusInternalServerError,
+		Code:     "internal_error",
+		Detail:   "the server encountered a problem and could not process your request",
+		Instance: r.URL.Path,
+		Error:    true,
+	}
+
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			p.Status = k.status
+			p.Code = k.code
+			p.Detail = k.kind.Error()
+			if k.detail != "" {
+				p.Detail = k.detail
+			}
+			break
+		}
+	}
+
+	var dataErr *data.Error
+	if p.Status != http.StatusInternalServerError && errors.As(err, &dataErr) {
+		if dataErr.Code != "" {
+			p.Code = dataErr.Code
+		}
+		if dataErr.Message != "" {
+			p.Detail = dataErr.Message
+		}
+	}
+
+	var fieldErrs data.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		p.Detail = "one or more fields are invalid"
+		p.Errors = fieldErrs
+	}
+
+	var stale *staleEdit
+	if errors.As(err, &stale) {
+		p.Current = stale.current
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the client went away; nobody is waiting for this response
+		app.loggerFor(r.Context()).Info("request cancelled by client", "method", r.Method, "path", r.URL.Path)
+		return
+	case p.Status >= http.StatusInternalServerError:
+		app.logError(r, err)
+	}
+
+	p.Title = http.StatusText(p.Status)
+	p.Message = p.Detail
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/problem+json")
+	headers.Set("Cache-Control", cacheNoStore)
+	if p.Status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", "Bearer")
+	}
+
+	err = app.writeJSON(w, p.Status, p, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/mailer"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Fines: chargeOverdue flags loans as they go past their due date, emails the
+// borrower, and charges app.config.fines.perDay for each day late after the
+// grace period, up to maxPerLoan. Staff record payments and waivers in the
+// user's ledger, and users owing more than blockThreshold can't check out
+// more copies. Amounts are in cents.
+
+// ledgerInput is a payment or waiver recorded by staff.
+type ledgerInput struct {
+	Kind   string `json:"kind" validate:"required,oneof=payment waiver"`
+	Amount int    `json:"amount" validate:"required"`
+	Note   string `json:"note" validate:"max=1000"`
+	LoanID int    `json:"loan_id"` // the loan whose fine is waived, optional
+}
+
+// finePolicy returns the configured charges for overdue loans.
+func (app *application) finePolicy() data.FinePolicy {
+	return data.FinePolicy{
+		PerDay:     app.config.fines.perDay,
+		Grace:      app.config.fines.gracePeriod,
+		MaxPerLoan: app.config.fines.maxPerLoan,
+	}
+}
+
+// ShowLedger shows the requester their own ledger, and staff anyone's.
+func (app *application) ShowLedger(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.actFor(r, userID, "view ledgers")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeLedger(w, r, http.StatusOK, "success", userID)
+}
+
+// RecordLedgerEntry records a payment or waiver against what the user owes.
+// Only staff can, and not on their own ledger; the requester is recorded as
+// the one who took it.
+func (app *application) RecordLedgerEntry(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	librarian := app.contextGetUser(r)
+	if librarian == nil || !librarian.Staff {
+		app.errorResponse(w, r, &data.Error{Kind: data.ErrForbidden, Code: "staff_only", Message: "only staff can record payments and waivers"})
+		return
+	}
+	if librarian.ID == userID {
+		app.errorResponse(w, r, &data.Error{Kind: data.ErrForbidden, Code: "own_ledger", Message: "payments and waivers can't be recorded on your own ledger"})
+		return
+	}
+
+	var input ledgerInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	v.Check(input.Amount >= 0, "amount", "must be a positive number of cents")
+	v.Check(input.LoanID >= 0, "loan_id", "must be positive")
+	v.Check(input.LoanID == 0 || input.Kind == data.LedgerWaiver, "loan_id", "can only be given for a waiver")
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	// a missing user is reported as such, rather than as owing nothing
+	ctx := r.Context()
+	_, err = app.models.Fine.Ledger(ctx, userID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	entry := data.LedgerEntry{UserID: userID, LoanID: input.LoanID, Kind: input.Kind, Amount: input.Amount, Note: input.Note, RecordedBy: librarian.ID}
+
+	_, err = app.models.Fine.Record(ctx, entry)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeLedger(w, r, http.StatusCreated, input.Kind+" recorded", userID)
+}
+
+// chargeOverdue flags the loans that went overdue since the last run, emails
+// their borrowers, and brings the fines of every overdue loan up to date.
+func (app *application) chargeOverdue(ctx context.Context) error {
+	ids, err := app.models.Fine.FlagOverdue(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		loan, err := app.models.Loan.GetOne(ctx, id)
+		if err != nil {
+			app.loggerFor(ctx).Error("overdue notice not sent", "loan", id, "error", err)
+			continue
+		}
+		app.sendOverdueMail(ctx, loan)
+	}
+
+	charged, err := app.models.Fine.Accrue(ctx, app.finePolicy(), 0)
+	if err != nil {
+		return err
+	}
+
+	if len(ids)+charged > 0 {
+		app.loggerFor(ctx).Info("overdue loans charged", "flagged", len(ids), "fines", charged)
+	}
+	return nil
+}
+
+// sendOverdueMail tells the borrower of loan it's overdue, and what that
+// costs.
+func (app *application) sendOverdueMail(ctx context.Context, loan *data.Loan) {
+	name := loan.FirstName
+	if name == "" {
+		name = "there"
+	}
+
+	body := fmt.Sprintf("Your loan of %q, barcode %s, was due back on %s.", loan.Title, loan.Barcode, mailTime(&loan.DueAt))
+	if policy := app.finePolicy(); policy.PerDay > 0 {
+		charged := loan.DueAt.Add(policy.Grace)
+		body += fmt.Sprintf(" Please return it as soon as you can; from %s it costs %s a day, up to %s.",
+			mailTime(&charged), cents(policy.PerDay), cents(policy.MaxPerLoan))
+	} else {
+		body += " Please return it as soon as you can."
+	}
+
+	app.sendMail(ctx, "loan_overdue", mailer.Message{
+		To:      loan.Email,
+		Subject: loan.Title + " is overdue",
+		Body:    "Hi " + name + ",\n\n" + body + "\n",
+	})
+}
+
+// cents formats an amount in cents, e.g. 1.25 for 125.
+func cents(amount int) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+// writeLedger responds with the ledger of the user with the given id.
+func (app *application) writeLedger(w http.ResponseWriter, r *http.Request, status int, message string, userID int) {
+	ledger, err := app.models.Fine.Ledger(r.Context(), userID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    envelope{"ledger": ledger},
+	}
+
+	err = app.writeJSON(w, status, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
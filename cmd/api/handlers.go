@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/jumaniyozov/gobook/internal/data"
-	"github.com/mozillazg/go-slugify"
+	"github.com/jumaniyozov/gobook/internal/validator"
 	"net/http"
 	"os"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,10 +29,16 @@ type jsonResponse struct {
 
 type envelope map[string]any
 
+var errInvalidCredentials = &data.Error{
+	Kind:    data.ErrUnauthorized,
+	Code:    "invalid_credentials",
+	Message: "invalid user credentials",
+}
+
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	type credentials struct {
-		UserName string `json:"email"`
-		Password string `json:"password"`
+		UserName string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var creds credentials
@@ -34,45 +46,56 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorLog.Println(err)
-		payload.Error = true
-		payload.Message = "invalid json supplied, or json missing entirely"
-		_ = app.writeJSON(w, http.StatusBadRequest, payload)
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Struct(&creds); !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
 	}
 
-	user, err := app.models.User.GetByEmail(creds.UserName)
+	user, err := app.models.User.GetByEmail(r.Context(), creds.UserName)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, errors.New("invalid user credentials"))
+		if errors.Is(err, data.ErrNotFound) {
+			app.metrics.logins.WithLabelValues("invalid_credentials").Inc()
+			err = errInvalidCredentials
+		}
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	validPassword, err := user.PasswordMatches(creds.Password)
-	if err != nil || !validPassword {
-		app.errorLog.Println(err)
-		app.errorJSON(w, errors.New("invalid user credentials"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	if !validPassword {
+		app.metrics.logins.WithLabelValues("invalid_credentials").Inc()
+		app.errorResponse(w, r, errInvalidCredentials)
 		return
 	}
 
 	if user.Active == 0 {
-		app.errorJSON(w, errors.New("invalid username/password"))
+		app.metrics.logins.WithLabelValues("user_inactive").Inc()
+		app.errorResponse(w, r, &data.Error{Kind: data.ErrForbidden, Code: "user_inactive", Message: "user not active"})
 		return
 	}
 
-	token, err := app.models.Token.GenerateToken(user.ID, 24*time.Hour)
+	token, err := app.models.Token.GenerateToken(user.ID, app.config.tokens.ttl)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Token.Insert(*token, *user)
+	err = app.models.Token.Insert(r.Context(), *token, *user)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, errors.New("invalid user credentials"))
+		app.errorResponse(w, r, err)
 		return
 	}
 
+	app.metrics.logins.WithLabelValues("success").Inc()
 	user.Password = ""
 
 	payload = jsonResponse{
@@ -83,15 +106,15 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logError(r, err)
 	}
 }
 
 func (app *application) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	var users data.User
-	all, err := users.GetAll()
+	all, err := users.GetAll(r.Context())
 	if err != nil {
-		app.errorLog.Println(err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -103,9 +126,7 @@ func (app *application) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
@@ -117,29 +138,26 @@ func (app *application) AddUser(w http.ResponseWriter, r *http.Request) {
 		Password:  "password",
 	}
 
-	app.infoLog.Println("Adding user...")
+	app.loggerFor(r.Context()).Info("adding user")
 
-	id, err := app.models.User.Insert(u)
+	id, err := app.models.User.Insert(r.Context(), u)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err, http.StatusForbidden)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	app.infoLog.Println("Got back id of", id)
-	newUser, _ := app.models.User.GetOne(id)
+	app.loggerFor(r.Context()).Info("user added", "id", id)
+	newUser, _ := app.models.User.GetOne(r.Context(), id)
 	err = app.writeJSON(w, http.StatusOK, newUser)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) GenerateToken(w http.ResponseWriter, r *http.Request) {
 	token, err := app.models.User.Token.GenerateToken(1, 60*time.Minute)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logError(r, err)
 		return
 	}
 
@@ -155,22 +173,20 @@ func (app *application) GenerateToken(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) SaveToken(w http.ResponseWriter, r *http.Request) {
 	token, err := app.models.User.Token.GenerateToken(2, 60*time.Minute)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logError(r, err)
 		return
 	}
 
-	user, err := app.models.User.GetOne(2)
+	user, err := app.models.User.GetOne(r.Context(), 2)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logError(r, err)
 		return
 	}
 
@@ -178,9 +194,9 @@ func (app *application) SaveToken(w http.ResponseWriter, r *http.Request) {
 	token.CreatedAt = time.Now()
 	token.UpdatedAt = time.Now()
 
-	err = token.Insert(*token, *user)
+	err = token.Insert(r.Context(), *token, *user)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logError(r, err)
 		return
 	}
 
@@ -195,18 +211,24 @@ func (app *application) SaveToken(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Token string `json:"token"`
+		Token string `json:"token" validate:"required"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid json"))
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Struct(&requestPayload); !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
 		return
 	}
 
-	err = app.models.Token.DeleteByToken(requestPayload.Token)
+	err = app.models.Token.DeleteByToken(r.Context(), requestPayload.Token)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid json"))
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -218,100 +240,199 @@ func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJSON(w, http.StatusOK, payload)
 }
 
+// userInput holds the editable fields of a user, as accepted by every route
+// that creates or changes one.
+type userInput struct {
+	Email     string `json:"email" validate:"required,email,max=255"`
+	FirstName string `json:"first_name" validate:"required,max=255"`
+	LastName  string `json:"last_name" validate:"required,max=255"`
+	Password  string `json:"password" validate:"min=8,max=72"`
+	Active    int    `json:"active" validate:"oneof=0 1"`
+	Version   int    `json:"version"` // the version edited, required to change an existing user
+}
+
+// Signup creates an account. It's public, so unlike EditUser it never
+// changes an existing user; an id in the body is refused.
+func (app *application) Signup(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+		userInput
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if requestPayload.ID != 0 {
+		app.errorResponse(w, r, data.FieldErrors{"id": {"must be left out, signing up only creates new users"}})
+		return
+	}
+
+	_, err = app.saveUser(r.Context(), 0, requestPayload.userInput)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
 func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var requestPayload struct {
+		ID int `json:"id"`
+		userInput
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	if user.ID == 0 {
-		if _, err := app.models.User.Insert(user); err != nil {
-			app.errorLog.Println(err)
-			app.errorJSON(w, err)
-			return
+	_, err = app.saveUser(r.Context(), requestPayload.ID, requestPayload.userInput)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// saveUser validates input and uses it to update the user with the given id,
+// or to create a new user when id is zero. The saved user is returned without
+// its password.
+func (app *application) saveUser(ctx context.Context, id int, input userInput) (*data.User, error) {
+	if id != 0 && input.Version == 0 {
+		return nil, errPreconditionRequired
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	v.Check(id != 0 || input.Password != "", "password", "must be provided")
+
+	if v.Errors["email"] == nil {
+		existing, err := app.models.User.GetByEmail(ctx, input.Email)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			return nil, err
 		}
-	} else {
+		v.Check(existing == nil || existing.ID == id, "email", "is already in use")
+	}
 
-		u, err := app.models.User.GetOne(user.ID)
-		if err != nil {
-			app.errorLog.Println(err)
-			app.errorJSON(w, err)
-			return
+	if !v.Valid() {
+		return nil, data.FieldErrors(v.Errors)
+	}
+
+	if id == 0 {
+		user := data.User{
+			Email:     input.Email,
+			FirstName: input.FirstName,
+			LastName:  input.LastName,
+			Password:  input.Password,
+			Active:    input.Active,
 		}
 
-		u.Email = user.Email
-		u.FirstName = user.FirstName
-		u.LastName = user.LastName
-		u.Active = user.Active
+		newID, err := app.models.User.Insert(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		id = newID
+	} else {
+		u, err := app.models.User.GetOne(ctx, id)
+		if err != nil {
+			return nil, err
+		}
 
-		if err := u.Update(); err != nil {
-			app.errorLog.Println(err)
-			app.errorJSON(w, err)
-			return
+		u.Email = input.Email
+		u.FirstName = input.FirstName
+		u.LastName = input.LastName
+		u.Active = input.Active
+		u.Version = input.Version
+
+		if err := u.Update(ctx); err != nil {
+			return nil, withCurrent(err, func() (any, error) {
+				current, err := app.models.User.GetOne(ctx, id)
+				if err != nil {
+					return nil, err
+				}
+				current.Password = ""
+				return current, nil
+			})
 		}
 
-		if user.Password != "" {
-			err := u.ResetPassword(user.Password)
+		if input.Password != "" {
+			err := u.ResetPassword(ctx, input.Password)
 			if err != nil {
-				app.errorLog.Println(err)
-				app.errorJSON(w, err)
-				return
+				return nil, err
 			}
 		}
 	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: "Changes saved",
-	}
-
-	err = app.writeJSON(w, http.StatusAccepted, payload)
+	user, err := app.models.User.GetOne(ctx, id)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		return nil, err
 	}
+	user.Password = ""
+
+	return user, nil
 }
 
 func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	userID, err := app.readIDParam(r)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	user, err := app.models.User.GetOne(userID)
+	user, err := app.models.User.GetOne(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	err = app.writeJSON(w, http.StatusOK, user)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		ID int `json:"id"`
+		ID int `json:"id" validate:"required,min=1"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Struct(&requestPayload); !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
 		return
 	}
 
-	err = app.models.User.DeleteByID(requestPayload.ID)
+	err = app.models.User.DeleteByID(r.Context(), requestPayload.ID)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -322,39 +443,33 @@ func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) LogUserOutAndSetInactive(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	userID, err := app.readIDParam(r)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	user, err := app.models.User.GetOne(userID)
+	user, err := app.models.User.GetOne(r.Context(), userID)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	user.Active = 0
-	err = user.Update()
+	err = user.Update(r.Context())
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Token.DeleteTokensForUser(userID)
+	err = app.models.Token.DeleteTokensForUser(r.Context(), userID)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -365,26 +480,29 @@ func (app *application) LogUserOutAndSetInactive(w http.ResponseWriter, r *http.
 
 	err = app.writeJSON(w, http.StatusAccepted, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) ValidateToken(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Token string `json:"token"`
+		Token string `json:"token" validate:"required"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Struct(&requestPayload); !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
 		return
 	}
 
-	valid := false
-	valid, _ = app.models.Token.ValidToken(requestPayload.Token)
+	valid, err := app.models.Token.ValidToken(r.Context(), requestPayload.Token)
+	app.metrics.tokenValidations.WithLabelValues(tokenResult(err)).Inc()
 
 	payload := jsonResponse{
 		Error:   false,
@@ -394,17 +512,41 @@ func (app *application) ValidateToken(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
+// AllBooks lists every book, or those of the publisher with the id in
+// ?publisher=. With ?q= it searches them, listing one edition of each matching
+// work.
 func (app *application) AllBooks(w http.ResponseWriter, r *http.Request) {
-	books, err := app.models.Book.GetAll()
+	filter := data.BookFilter{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if publisher := r.URL.Query().Get("publisher"); publisher != "" {
+		id, err := strconv.Atoi(publisher)
+		if err != nil || id < 1 {
+			app.errorResponse(w, r, badRequest("invalid_publisher", "publisher must be a positive integer", err))
+			return
+		}
+		filter.PublisherID = id
+	}
+
+	count, modified, err := app.models.Book.CatalogVersion(r.Context())
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, strongETag("books", count, modified, filter.Query, filter.PublisherID), modified) {
+		return
+	}
+
+	var books []*data.Book
+	if filter != (data.BookFilter{}) {
+		books, err = app.models.Book.Search(r.Context(), filter)
+	} else {
+		books, err = app.models.Book.GetAll(r.Context())
+	}
+	if err != nil {
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -416,19 +558,29 @@ func (app *application) AllBooks(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) OneBook(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 
-	book, err := app.models.Book.GetOneBySlug(slug)
+	book, err := app.models.Book.GetOneBySlug(r.Context(), slug)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		// the book may have been renamed since the link was shared
+		if errors.Is(err, data.ErrNotFound) {
+			if current, slugErr := app.models.Book.CurrentSlug(r.Context(), slug); slugErr == nil {
+				http.Redirect(w, r, path.Join(path.Dir(r.URL.Path), current), http.StatusMovedPermanently)
+				return
+			}
+		}
+
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	etag, modified := bookValidators(book)
+	if app.notModified(w, r, etag, modified) {
 		return
 	}
 
@@ -439,17 +591,14 @@ func (app *application) OneBook(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) AuthorsAll(w http.ResponseWriter, r *http.Request) {
-	all, err := app.models.Author.All()
+	all, err := app.models.Author.All(r.Context())
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -476,96 +625,409 @@ func (app *application) AuthorsAll(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
+// bookInput holds the editable fields of a book, as accepted by every route
+// that creates or changes one.
+type bookInput struct {
+	Title           string `json:"title" validate:"required,max=512"`
+	AuthorID        int    `json:"author_id" validate:"min=1"` // the first author, see bookContributors
+	PublicationYear int    `json:"publication_year" validate:"required,min=1"`
+	Description     string `json:"description"`
+	CoverBase64     string `json:"cover"`
+	GenreIDs        []int  `json:"genre_ids" validate:"unique"`
+	Version         int    `json:"version"` // the version edited, required to change an existing book
+
+	Contributors []contributorInput `json:"contributors"`
+
+	// the edition; a zero work_id makes a new book the first edition of a new
+	// work, and keeps an existing one in its work
+	WorkID      int    `json:"work_id" validate:"min=1"`
+	Format      string `json:"format" validate:"oneof=hardcover paperback ebook audiobook"`
+	PublisherID int    `json:"publisher_id" validate:"min=1"`
+	ImprintID   int    `json:"imprint_id" validate:"min=1"` // implies its publisher when publisher_id is left out
+	PageCount   int    `json:"page_count" validate:"min=1"`
+	Language    string `json:"language" validate:"max=35,language"`
+
+	// identifiers that are left out keep their value, and empty ones are removed
+	ISBN *string `json:"isbn"`
+	OCLC *string `json:"oclc"`
+	LCCN *string `json:"lccn"`
+	DOI  *string `json:"doi"`
+
+	cover []byte // a decoded cover, used instead of CoverBase64 when set
+}
+
+// contributorInput is an author's part in a book, as sent by clients. The
+// order they're listed in is kept.
+type contributorInput struct {
+	AuthorID int    `json:"author_id"`
+	Role     string `json:"role"`
+}
+
 func (app *application) EditBok(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		ID              int    `json:"id"`
-		Title           string `json:"title"`
-		AuthorID        int    `json:"author_id"`
-		PublicationYear int    `json:"publication_year"`
-		Description     string `json:"description"`
-		CoverBase64     string `json:"cover"`
-		GenreIDs        []int  `json:"genre_ids"`
+		ID int `json:"id"`
+		bookInput
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	book := data.Book{
-		ID:              requestPayload.ID,
-		Title:           requestPayload.Title,
-		AuthorID:        requestPayload.AuthorID,
-		PublicationYear: requestPayload.PublicationYear,
-		Description:     requestPayload.Description,
-		Slug:            slugify.Slugify(requestPayload.Title),
-		GenreIDs:        requestPayload.GenreIDs,
+	_, err = app.saveBook(r.Context(), requestPayload.ID, requestPayload.bookInput)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// saveBook validates input and uses it to update the book with the given id,
+// or to create a new book when id is zero, storing the cover if one was sent.
+// It returns the saved book.
+func (app *application) saveBook(ctx context.Context, id int, input bookInput) (*data.Book, error) {
+	if id != 0 && input.Version == 0 {
+		return nil, errPreconditionRequired
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	v.Check(input.PublicationYear <= time.Now().Year()+1, "publication_year", "must not be in the future")
+
+	cover := input.cover
+	if len(input.CoverBase64) > 0 {
+		var err error
+		cover, err = base64.StdEncoding.DecodeString(input.CoverBase64)
+		v.Check(err == nil, "cover", "must be a base64 encoded image")
 	}
 
-	if len(requestPayload.CoverBase64) > 0 {
-		decoded, err := base64.StdEncoding.DecodeString(requestPayload.CoverBase64)
+	var existing *data.Book
+	if id != 0 {
+		var err error
+		existing, err = app.models.Book.GetOneById(ctx, id)
 		if err != nil {
-			app.errorLog.Println(err)
-			app.errorJSON(w, err)
-			return
+			return nil, err
 		}
+	}
 
-		if err := os.WriteFile(fmt.Sprintf("%s/covers/%s.jpg", staticPath, book.Slug), decoded, 0666); err != nil {
-			app.errorLog.Println(err)
-			app.errorJSON(w, err)
-			return
+	contributors := bookContributors(v, input, existing)
+
+	var identifiers data.Book
+	bookIdentifiers(v, &identifiers, input, existing)
+
+	// errors about authors go where the client named them
+	authorField := "contributors"
+	if input.Contributors == nil {
+		authorField = "author_id"
+	}
+
+	err := app.checkBookReferences(ctx, v, authorField, contributors, input.GenreIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.WorkID != 0 && (existing == nil || input.WorkID != existing.WorkID) {
+		exists, err := app.models.Work.Exists(ctx, input.WorkID)
+		if err != nil {
+			return nil, err
 		}
+		v.Check(exists, "work_id", "must refer to an existing work")
+	}
 
-		if book.ID == 0 {
-			_, err := app.models.Book.Insert(book)
+	publisherID, err := app.bookPublisher(ctx, v, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.Valid() {
+		return nil, data.FieldErrors(v.Errors)
+	}
+
+	book := data.Book{
+		ID:              id,
+		Title:           input.Title,
+		AuthorID:        firstAuthor(contributors),
+		PublicationYear: input.PublicationYear,
+		Description:     input.Description,
+		GenreIDs:        input.GenreIDs,
+		Version:         input.Version,
+		Contributors:    contributors,
+		ISBN13:          identifiers.ISBN13,
+		OCLC:            identifiers.OCLC,
+		LCCN:            identifiers.LCCN,
+		DOI:             identifiers.DOI,
+		WorkID:          input.WorkID,
+		Format:          input.Format,
+		PublisherID:     publisherID,
+		ImprintID:       input.ImprintID,
+		PageCount:       input.PageCount,
+		Language:        input.Language,
+	}
+	if len(cover) > 0 {
+		book.CoverKey = coverKey(cover)
+	}
+	if editor := requestInfoFrom(ctx).user; editor != nil {
+		book.EditorID = editor.ID
+	}
+
+	// the slug is decided by the data layer, so covers are written once we know it
+	if book.ID == 0 {
+		book.ID, err = app.models.Book.Insert(ctx, book)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = book.Update(ctx)
+		if err != nil {
+			return nil, withCurrent(err, func() (any, error) {
+				return app.models.Book.GetOneById(ctx, book.ID)
+			})
+		}
+
+		if existing.Slug != book.Slug && len(cover) == 0 {
+			err := traceFileOp(ctx, "cover.rename", coverPath(book.Slug), func() error {
+				err := os.Rename(coverPath(existing.Slug), coverPath(book.Slug))
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			})
 			if err != nil {
-				app.errorLog.Println(err)
-				app.errorJSON(w, err)
-				return
+				app.loggerFor(ctx).Error("cover not renamed", "error", err)
 			}
-		} else {
-			err := book.Update()
-			if err != nil {
-				app.errorLog.Println(err)
-				app.errorJSON(w, err)
-				return
+		}
+	}
+
+	saved, err := app.models.Book.GetOneById(ctx, book.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cover) > 0 {
+		app.metrics.coverBytes.Observe(float64(len(cover)))
+		err := traceFileOp(ctx, "cover.write", coverPath(saved.Slug), func() error {
+			return os.WriteFile(coverPath(saved.Slug), cover, 0666)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// revisions refer to covers by key, so keep a copy to revert to
+		err = traceFileOp(ctx, "cover.keep", coverRevisionPath(book.CoverKey), func() error {
+			return keepCover(book.CoverKey, cover)
+		})
+		if err != nil {
+			app.loggerFor(ctx).Error("cover not kept for revisions", "error", err)
+		}
+	}
+
+	return saved, nil
+}
+
+// bookContributors works out who contributed to the book being saved, in
+// order. Contributors sent by the client are taken as they are, and author_id,
+// if also sent, must be the first author among them. Otherwise a new book has
+// author_id as its only author, and an existing book keeps its contributors
+// with author_id taking the place of its first author. Problems are recorded
+// on v.
+func bookContributors(v *validator.Validator, input bookInput, existing *data.Book) []data.Contributor {
+	if input.Contributors == nil {
+		v.Check(input.AuthorID != 0, "author_id", "must be provided")
+
+		primary := data.Contributor{AuthorID: input.AuthorID, Role: "author"}
+		if existing == nil {
+			return []data.Contributor{primary}
+		}
+
+		contributors := []data.Contributor{}
+		replaced := false
+		for _, c := range existing.Contributors {
+			c = data.Contributor{AuthorID: c.AuthorID, Role: c.Role}
+			switch {
+			case c.Role == "author" && !replaced:
+				c, replaced = primary, true
+			case c == primary:
+				continue
 			}
+			contributors = append(contributors, c)
+		}
+		if !replaced {
+			contributors = append([]data.Contributor{primary}, contributors...)
 		}
+		return contributors
 	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: "Changes saved",
+	contributors := make([]data.Contributor, 0, len(input.Contributors))
+	seen := make(map[data.Contributor]bool)
+	for i, c := range input.Contributors {
+		contributor := data.Contributor{AuthorID: c.AuthorID, Role: c.Role}
+
+		if c.AuthorID < 1 {
+			v.AddError("contributors", fmt.Sprintf("contributor %d must have an author_id", i+1))
+		}
+		if !slices.Contains(data.ContributorRoles, c.Role) {
+			v.AddError("contributors", fmt.Sprintf("contributor %d must have a role of %s", i+1, strings.Join(data.ContributorRoles, ", ")))
+		}
+		if seen[contributor] {
+			v.AddError("contributors", fmt.Sprintf("author %d is listed as %s more than once", c.AuthorID, c.Role))
+		}
+
+		seen[contributor] = true
+		contributors = append(contributors, contributor)
 	}
 
-	err = app.writeJSON(w, http.StatusAccepted, payload)
+	first := firstAuthor(contributors)
+	v.Check(first != 0, "contributors", "must include someone with the author role")
+	v.Check(input.AuthorID == 0 || first == 0 || input.AuthorID == first, "author_id", "must be the first author in contributors")
+
+	return contributors
+}
+
+// firstAuthor returns the id of the first contributor with the author role, or
+// zero when there is none.
+func firstAuthor(contributors []data.Contributor) int {
+	for _, c := range contributors {
+		if c.Role == "author" {
+			return c.AuthorID
+		}
+	}
+	return 0
+}
+
+// checkBookReferences records an error on v for contributors or genres that
+// don't exist, putting errors about contributors under authorField. Fields that
+// already failed validation aren't looked up.
+func (app *application) checkBookReferences(ctx context.Context, v *validator.Validator, authorField string, contributors []data.Contributor, genreIDs []int) error {
+	if v.Errors[authorField] == nil {
+		var ids []int
+		for _, c := range contributors {
+			if !slices.Contains(ids, c.AuthorID) {
+				ids = append(ids, c.AuthorID)
+			}
+		}
+
+		missing, err := app.models.Author.Missing(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range missing {
+			if authorField == "author_id" {
+				v.AddError(authorField, "must refer to an existing author")
+				continue
+			}
+			v.AddError(authorField, fmt.Sprintf("author %d does not exist", id))
+		}
+	}
+
+	if len(genreIDs) > 0 && v.Errors["genre_ids"] == nil {
+		missing, err := app.models.Genre.Missing(ctx, genreIDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range missing {
+			v.AddError("genre_ids", fmt.Sprintf("genre %d does not exist", id))
+		}
+	}
+
+	return nil
+}
+
+// bookPublisher checks the publisher and imprint of input, recording problems
+// on v, and returns the id of the publisher. That is the imprint's publisher
+// when only an imprint was sent.
+func (app *application) bookPublisher(ctx context.Context, v *validator.Validator, input bookInput) (int, error) {
+	publisherID := input.PublisherID
+
+	if input.ImprintID != 0 {
+		imprint, err := app.models.Publisher.Imprint(ctx, input.ImprintID)
+		if errors.Is(err, data.ErrNotFound) {
+			v.AddError("imprint_id", "must refer to an existing imprint")
+			return publisherID, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if publisherID == 0 {
+			publisherID = imprint.PublisherID
+		}
+		v.Check(imprint.PublisherID == publisherID, "imprint_id", "must be an imprint of the publisher")
+		return publisherID, nil
+	}
+
+	if publisherID != 0 {
+		_, err := app.models.Publisher.GetOne(ctx, publisherID)
+		if errors.Is(err, data.ErrNotFound) {
+			v.AddError("publisher_id", "must refer to an existing publisher")
+			return publisherID, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return publisherID, nil
+}
+
+// coverPath returns where the cover image for the book with slug is stored.
+func coverPath(slug string) string {
+	return fmt.Sprintf("%s/covers/%s.jpg", staticPath, slug)
+}
+
+// coverKey identifies a cover image by its content.
+func coverKey(cover []byte) string {
+	sum := sha256.Sum256(cover)
+	return hex.EncodeToString(sum[:8])
+}
+
+// coverRevisionPath returns where the cover with key is kept for revisions.
+func coverRevisionPath(key string) string {
+	return fmt.Sprintf("%s/covers/revisions/%s.jpg", staticPath, key)
+}
+
+// keepCover stores a copy of cover under key, unless one is already there.
+func keepCover(key string, cover []byte) error {
+	dst := coverRevisionPath(key)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(path.Dir(dst), 0777)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		return err
 	}
+	return os.WriteFile(dst, cover, 0666)
 }
 
 func (app *application) BookByID(w http.ResponseWriter, r *http.Request) {
-	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	bookID, err := app.readIDParam(r)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	book, err := app.models.Book.GetOneById(bookID)
+	book, err := app.models.Book.GetOneById(r.Context(), bookID)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	etag, modified := bookValidators(book)
+	if app.notModified(w, r, etag, modified) {
 		return
 	}
 
@@ -576,24 +1038,20 @@ func (app *application) BookByID(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
 
 func (app *application) DeleteBook(w http.ResponseWriter, r *http.Request) {
-	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	bookID, err := app.readIDParam(r)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Book.DeleteByID(bookID)
+	err = app.models.Book.DeleteByID(r.Context(), bookID)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -604,8 +1062,6 @@ func (app *application) DeleteBook(w http.ResponseWriter, r *http.Request) {
 
 	err = app.writeJSON(w, http.StatusOK, payload)
 	if err != nil {
-		app.errorLog.Println(err)
-		app.errorJSON(w, err)
-		return
+		app.logError(r, err)
 	}
 }
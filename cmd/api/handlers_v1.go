@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Handlers for the resource oriented /v1 routes. Routes whose behaviour is the
+// same in both surfaces, such as AllBooks or DeleteBook, are shared with the
+// legacy routes and live in handlers.go.
+
+func (app *application) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	token, err := app.models.Token.BearerToken(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Token.DeleteByToken(r.Context(), token)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "logged out",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var input userInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.saveUser(r.Context(), 0, input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d", user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user created",
+		Data:    envelope{"user": user},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) ShowUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.User.GetOne(r.Context(), userID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	user.Password = ""
+
+	etag, modified := userValidators(user)
+	if app.notModified(w, r, etag, modified) {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"user": user},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// UpdateUser replaces every editable field of a user. The password is only
+// changed when one is sent.
+func (app *application) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var input userInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if r.Header.Get("If-Match") != "" {
+		user, err := app.models.User.GetOne(r.Context(), userID)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		user.Password = ""
+
+		etag, _ := userValidators(user)
+		if err := ifMatch(r, etag, user); err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if input.Version == 0 {
+			input.Version = user.Version
+		}
+	}
+
+	app.writeSavedUser(w, r, userID, input)
+}
+
+// PatchUser changes only the fields present in the request body.
+func (app *application) PatchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.User.GetOne(r.Context(), userID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	user.Password = ""
+
+	etag, _ := userValidators(user)
+	if err := ifMatch(r, etag, user); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// fields missing from the body keep their current values, and the edit
+	// only applies to the version they were read from
+	input := userInput{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Active:    user.Active,
+		Version:   user.Version,
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeSavedUser(w, r, userID, input)
+}
+
+func (app *application) writeSavedUser(w http.ResponseWriter, r *http.Request, userID int, input userInput) {
+	user, err := app.saveUser(r.Context(), userID, input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	etag, _ := userValidators(user)
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+		Data:    envelope{"user": user},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) DeleteUserByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.User.DeleteByID(r.Context(), userID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) ListAuthors(w http.ResponseWriter, r *http.Request) {
+	all, err := app.models.Author.All(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"authors": all},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// ShowAuthor returns an author with every book they contributed to, in any
+// role.
+func (app *application) ShowAuthor(w http.ResponseWriter, r *http.Request) {
+	authorID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	author, err := app.models.Author.GetOne(r.Context(), authorID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	works, err := app.models.Author.Contributions(r.Context(), authorID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"author": author, "works": works},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// DeleteAuthor moves an author to the trash. Their books have to be deleted
+// first.
+func (app *application) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	authorID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Author.DeleteByID(r.Context(), authorID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Author deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) CreateBook(w http.ResponseWriter, r *http.Request) {
+	var input bookInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	book, err := app.saveBook(r.Context(), 0, input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/books/%d", book.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "book created",
+		Data:    envelope{"book": book},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// UpdateBook replaces every editable field of a book. The cover is only
+// changed when one is sent.
+func (app *application) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var input bookInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if r.Header.Get("If-Match") != "" {
+		book, err := app.models.Book.GetOneById(r.Context(), bookID)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+
+		if err := ifMatch(r, bookEditTag(book), book); err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if input.Version == 0 {
+			input.Version = book.Version
+		}
+	}
+
+	app.writeSavedBook(w, r, bookID, input)
+}
+
+// PatchBook changes only the fields present in the request body.
+func (app *application) PatchBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	book, err := app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := ifMatch(r, bookEditTag(book), book); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// fields missing from the body keep their current values, and the edit
+	// only applies to the version they were read from
+	input := bookInput{
+		Title:           book.Title,
+		AuthorID:        book.AuthorID,
+		PublicationYear: book.PublicationYear,
+		Description:     book.Description,
+		GenreIDs:        book.GenreIDs,
+		Version:         book.Version,
+		WorkID:          book.WorkID,
+		Format:          book.Format,
+		PublisherID:     book.PublisherID,
+		ImprintID:       book.ImprintID,
+		PageCount:       book.PageCount,
+		Language:        book.Language,
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// new contributors decide the first author, unless author_id was changed too
+	if input.Contributors != nil && input.AuthorID == book.AuthorID {
+		input.AuthorID = 0
+	}
+
+	// a new publisher doesn't keep the old one's imprint
+	if input.PublisherID != book.PublisherID && input.ImprintID == book.ImprintID {
+		input.ImprintID = 0
+	}
+
+	app.writeSavedBook(w, r, bookID, input)
+}
+
+func (app *application) writeSavedBook(w http.ResponseWriter, r *http.Request, bookID int, input bookInput) {
+	book, err := app.saveBook(r.Context(), bookID, input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	etag, _ := bookValidators(book)
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+		Data:    envelope{"book": book},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/sql/migrations"
+)
+
+// version is set at build time with -ldflags "-X main.version=...". Without it
+// the VCS revision recorded by the Go toolchain is reported instead.
+var version = "dev"
+
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return version + "+" + s.Value
+		}
+	}
+	return version
+}
+
+// checkResult is the outcome of one readiness check.
+type checkResult struct {
+	Status  string  `json:"status"`
+	Detail  string  `json:"detail,omitempty"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// readinessCheck is run by Readyz. It returns a short detail on success and an
+// error when the API shouldn't receive traffic. Details are shown to anyone, so
+// they mustn't reveal hosts, paths or credentials; errors are only logged.
+type readinessCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+func (app *application) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{"database", app.checkDatabase},
+		{"migrations", app.checkMigrations},
+		{"storage", app.checkStorage},
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) (string, error) {
+	err := app.models.Schema.Ping(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "reachable", nil
+}
+
+func (app *application) checkMigrations(ctx context.Context) (string, error) {
+	latest, err := migrations.Latest()
+	if err != nil {
+		return "", err
+	}
+
+	current, dirty, err := app.models.Schema.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case dirty:
+		return "", fmt.Errorf("migration %d failed part way and needs fixing by hand", current)
+	case current < latest:
+		return "", fmt.Errorf("database is at version %d, expected %d", current, latest)
+	}
+	return fmt.Sprintf("version %d", current), nil
+}
+
+// checkStorage makes sure covers can still be written.
+func (app *application) checkStorage(_ context.Context) (string, error) {
+	dir := filepath.Dir(coverPath("probe"))
+
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	f.Close()
+
+	err = os.Remove(name)
+	if err != nil {
+		return "", err
+	}
+	return "writable", nil
+}
+
+// Healthz reports that the process is up. It deliberately checks nothing else,
+// so a struggling database doesn't get the API restarted.
+func (app *application) Healthz(w http.ResponseWriter, r *http.Request) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: "alive",
+	}
+
+	err := app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// Readyz reports whether the API can serve requests, with the result of each
+// check. Any failing check makes it answer 503; why it failed is logged rather
+// than returned, since the endpoint is public.
+func (app *application) Readyz(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	checks := make(map[string]checkResult)
+
+	for _, c := range app.readinessChecks() {
+		start := time.Now()
+		detail, err := c.run(r.Context())
+
+		result := checkResult{Status: "ok", Detail: detail, Latency: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			result.Status = "failing"
+			result.Detail = ""
+			app.loggerFor(r.Context()).Error("readiness check failed", "check", c.name, "error", err)
+		}
+		checks[c.name] = result
+	}
+
+	payload := jsonResponse{
+		Error:   status != http.StatusOK,
+		Message: "ready",
+		Data:    envelope{"checks": checks},
+	}
+	if payload.Error {
+		payload.Message = "not ready"
+	}
+
+	err := app.writeJSON(w, status, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// Diagnostics describes the running instance for operators.
+func (app *application) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	stats := app.models.Schema.Stats()
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data: envelope{
+			"version":    buildVersion(),
+			"go_version": runtime.Version(),
+			"started_at": app.started,
+			"uptime":     time.Since(app.started).Round(time.Second).String(),
+			"goroutines": runtime.NumGoroutine(),
+			"config":     app.configSummary,
+			"db_pool": envelope{
+				"max_open_connections": stats.MaxOpenConnections,
+				"open_connections":     stats.OpenConnections,
+				"in_use":               stats.InUse,
+				"idle":                 stats.Idle,
+				"wait_count":           stats.WaitCount,
+				"wait_duration":        stats.WaitDuration.String(),
+				"max_idle_closed":      stats.MaxIdleClosed,
+				"max_lifetime_closed":  stats.MaxLifetimeClosed,
+			},
+		},
+	}
+
+	err := app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/mailer"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Holds queue users for a book whose copies are all out. A returned copy is
+// put aside for the first in line, who has app.config.holds.pickupWindow to
+// check it out before expireHolds passes it on. Users are emailed as their
+// hold is placed, becomes ready, expires or is cancelled.
+
+// holdInput names who a hold is for, the requester unless given.
+type holdInput struct {
+	UserID int `json:"user_id"`
+}
+
+// ListHolds lists holds, oldest first, optionally only those of one user
+// (?user_id=), of one book (?book_id=) or in one state (?status=). Only staff
+// see other users' holds.
+func (app *application) ListHolds(w http.ResponseWriter, r *http.Request) {
+	var filter data.HoldFilter
+	for param, id := range map[string]*int{"user_id": &filter.UserID, "book_id": &filter.BookID} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			app.errorResponse(w, r, badRequest("invalid_"+param, param+" must be a positive integer", err))
+			return
+		}
+		*id = n
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !slices.Contains(data.HoldStatuses, status) {
+			app.errorResponse(w, r, badRequest("invalid_status", "status must be one of "+strings.Join(data.HoldStatuses, ", "), nil))
+			return
+		}
+		filter.Status = status
+	}
+
+	var err error
+	filter.UserID, err = app.listFor(r, filter.UserID, "list holds")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	holds, err := app.models.Hold.All(r.Context(), filter)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"holds": holds},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// ShowHold shows a hold of the requester, or of anyone when they're staff.
+func (app *application) ShowHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	hold, err := app.models.Hold.GetOne(r.Context(), holdID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.showFor(r, hold.UserID, "hold")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeHold(w, r, http.StatusOK, "success", holdID)
+}
+
+// PlaceHold queues a user for the book; only staff can queue anyone but
+// themselves. When a copy is on the shelf, and nobody is ahead in line, it's
+// put aside for them at once.
+func (app *application) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// the body is optional, holds are for the requester by default
+	var input holdInput
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	v.Check(input.UserID >= 0, "user_id", "must be positive")
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	if input.UserID == 0 {
+		if user := app.contextGetUser(r); user != nil {
+			input.UserID = user.ID
+		}
+	}
+
+	err = app.actFor(r, input.UserID, "place holds")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	id, err := app.models.Hold.Place(ctx, bookID, input.UserID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.allocateBook(ctx, bookID)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	// a hold that became ready has been notified already
+	hold, err := app.models.Hold.GetOne(ctx, id)
+	if err == nil && hold.Status == data.HoldWaiting {
+		app.sendHoldMail(ctx, hold)
+	}
+
+	app.writeHold(w, r, http.StatusCreated, "hold placed", id)
+}
+
+// CancelHold cancels a waiting or ready hold of the requester, or of anyone
+// when they're staff. The copy put aside for a ready hold goes to the next in
+// line.
+func (app *application) CancelHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	hold, err := app.models.Hold.GetOne(ctx, holdID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.actFor(r, hold.UserID, "cancel holds")
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	copyID, err := app.models.Hold.Cancel(ctx, holdID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.notifyHold(ctx, holdID)
+	if copyID != 0 {
+		err = app.allocateCopy(ctx, copyID)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	app.writeHold(w, r, http.StatusOK, "hold cancelled", holdID)
+}
+
+// allocateCopy puts the copy with the given id aside for the first waiting
+// hold on its book, if there is one, and tells its user.
+func (app *application) allocateCopy(ctx context.Context, copyID int) error {
+	holdID, err := app.models.Hold.Allocate(ctx, copyID, app.config.holds.pickupWindow)
+	if err != nil || holdID == 0 {
+		return err
+	}
+
+	app.notifyHold(ctx, holdID)
+	return nil
+}
+
+// allocateBook allocates every available copy of the book with the given id,
+// or of every book when it's 0, that a hold is waiting for.
+func (app *application) allocateBook(ctx context.Context, bookID int) error {
+	copyIDs, err := app.models.Hold.CopiesToAllocate(ctx, bookID)
+	if err != nil {
+		return err
+	}
+
+	for _, copyID := range copyIDs {
+		err := app.allocateCopy(ctx, copyID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireHolds ends the ready holds that weren't picked up in time and passes
+// their copies on. It also allocates copies left on the shelf while holds are
+// waiting, say after a failed allocation or a lost copy being found.
+func (app *application) expireHolds(ctx context.Context) error {
+	ids, err := app.models.Hold.Expire(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		app.notifyHold(ctx, id)
+	}
+	if len(ids) > 0 {
+		app.loggerFor(ctx).Info("holds expired", "holds", len(ids))
+	}
+
+	return app.allocateBook(ctx, 0)
+}
+
+// notifyHold emails the user of the hold with the given id about its status.
+// Failures are logged; the change to the hold stands either way.
+func (app *application) notifyHold(ctx context.Context, id int) {
+	hold, err := app.models.Hold.GetOne(ctx, id)
+	if err != nil {
+		app.loggerFor(ctx).Error("hold notification not sent", "hold", id, "error", err)
+		return
+	}
+
+	app.sendHoldMail(ctx, hold)
+}
+
+// sendHoldMail emails the user of hold about its status. Fulfilled holds
+// need no email; the user has the copy.
+func (app *application) sendHoldMail(ctx context.Context, hold *data.Hold) {
+	name := hold.FirstName
+	if name == "" {
+		name = "there"
+	}
+
+	var subject, body string
+	switch hold.Status {
+	case data.HoldWaiting:
+		subject = "You're on the waiting list for " + hold.Title
+		body = fmt.Sprintf("You're number %d in line for %q. We'll email you when a copy is put aside for you.", hold.Position, hold.Title)
+	case data.HoldReady:
+		subject = hold.Title + " is ready to pick up"
+		body = fmt.Sprintf("A copy of %q, barcode %s, is put aside for you. Please check it out by %s, after which it goes to the next in line.",
+			hold.Title, hold.Barcode, mailTime(hold.ExpiresAt))
+	case data.HoldExpired:
+		subject = "Your hold on " + hold.Title + " has expired"
+		body = fmt.Sprintf("The copy of %q put aside for you wasn't checked out by %s, so your hold has ended. You can place a new hold at any time.",
+			hold.Title, mailTime(hold.ExpiresAt))
+	case data.HoldCancelled:
+		subject = "Your hold on " + hold.Title + " was cancelled"
+		body = fmt.Sprintf("Your hold on %q was cancelled. You can place a new hold at any time.", hold.Title)
+	default:
+		return
+	}
+
+	app.sendMail(ctx, "hold_"+hold.Status, mailer.Message{
+		To:      hold.Email,
+		Subject: subject,
+		Body:    "Hi " + name + ",\n\n" + body + "\n",
+	})
+}
+
+// mailTime formats t for people, in UTC since we don't know the reader's
+// time zone.
+func mailTime(t *time.Time) string {
+	if t == nil {
+		return "the end of the pickup window"
+	}
+	return t.UTC().Format("Monday 2 January 2006, 15:04 MST")
+}
+
+// writeHold responds with the hold with the given id.
+func (app *application) writeHold(w http.ResponseWriter, r *http.Request, status int, message string, id int) {
+	hold, err := app.models.Hold.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var headers []http.Header
+	if status == http.StatusCreated {
+		h := make(http.Header)
+		h.Set("Location", fmt.Sprintf("/v1/holds/%d", id))
+		headers = append(headers, h)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    envelope{"hold": hold},
+	}
+
+	err = app.writeJSON(w, status, payload, headers...)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/identifier"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// identifierMessages says what's wrong with an identifier the identifier
+// package refused, in the words used for other invalid fields.
+var identifierMessages = map[error]string{
+	identifier.ErrISBNFormat:   "must have 10 or 13 digits, the last of an ISBN-10 may be X",
+	identifier.ErrISBNChecksum: "has a check digit that doesn't match",
+	identifier.ErrISBNPrefix:   "must start with 978 or 979 when it has 13 digits",
+	identifier.ErrOCLC:         "must be digits, optionally prefixed with ocm, ocn, on or (OCoLC)",
+	identifier.ErrLCCN:         "must be up to three letters followed by a year and serial number",
+	identifier.ErrDOI:          "must look like 10.1000/xyz",
+}
+
+// lookupNormalizers normalizes the value of each identifier books can be
+// looked up by.
+var lookupNormalizers = map[string]func(string) (string, error){
+	data.ISBN: identifier.ISBN13,
+	data.OCLC: identifier.OCLC,
+	data.LCCN: identifier.LCCN,
+	data.DOI:  identifier.DOI,
+}
+
+// bookIdentifiers sets the identifiers of book from input. Identifiers left
+// out of input keep the value they have on existing, if there is one, and
+// empty ones are removed. Problems are recorded on v.
+func bookIdentifiers(v *validator.Validator, book *data.Book, input bookInput, existing *data.Book) {
+	if existing != nil {
+		book.ISBN13, book.OCLC, book.LCCN, book.DOI = existing.ISBN13, existing.OCLC, existing.LCCN, existing.DOI
+	}
+
+	normalizeIdentifier(v, "isbn", input.ISBN, &book.ISBN13, identifier.ISBN13)
+	normalizeIdentifier(v, "oclc", input.OCLC, &book.OCLC, identifier.OCLC)
+	normalizeIdentifier(v, "lccn", input.LCCN, &book.LCCN, identifier.LCCN)
+	normalizeIdentifier(v, "doi", input.DOI, &book.DOI, identifier.DOI)
+}
+
+// normalizeIdentifier stores the normalized form of value in dst, when value
+// was sent.
+func normalizeIdentifier(v *validator.Validator, field string, value *string, dst *string, normalize func(string) (string, error)) {
+	if value == nil {
+		return
+	}
+	if strings.TrimSpace(*value) == "" {
+		*dst = ""
+		return
+	}
+
+	normalized, err := normalize(*value)
+	if err != nil {
+		v.AddError(field, identifierMessages[err])
+		return
+	}
+	*dst = normalized
+}
+
+// BookByISBN looks a book up by its ISBN, given with 10 or 13 digits.
+func (app *application) BookByISBN(w http.ResponseWriter, r *http.Request) {
+	app.writeBookByIdentifier(w, r, data.ISBN, chi.URLParam(r, "isbn"))
+}
+
+// LookupBook looks a book up by exactly one of the isbn, oclc, lccn or doi
+// query parameters.
+func (app *application) LookupBook(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var kind string
+	for _, k := range []string{data.ISBN, data.OCLC, data.LCCN, data.DOI} {
+		if !query.Has(k) {
+			continue
+		}
+		if kind != "" {
+			app.errorResponse(w, r, badRequest("invalid_lookup", "look books up by only one of isbn, oclc, lccn or doi", nil))
+			return
+		}
+		kind = k
+	}
+	if kind == "" {
+		app.errorResponse(w, r, badRequest("invalid_lookup", "one of isbn, oclc, lccn or doi must be given", nil))
+		return
+	}
+
+	app.writeBookByIdentifier(w, r, kind, query.Get(kind))
+}
+
+func (app *application) writeBookByIdentifier(w http.ResponseWriter, r *http.Request, kind, value string) {
+	normalized, err := lookupNormalizers[kind](value)
+	if err != nil {
+		app.errorResponse(w, r, badRequest("invalid_"+kind, kind+" "+identifierMessages[err], err))
+		return
+	}
+
+	book, err := app.models.Book.GetOneByIdentifier(r.Context(), kind, normalized)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	etag, modified := bookValidators(book)
+	if app.notModified(w, r, etag, modified) {
+		return
+	}
+
+	payload := jsonResponse{
+		Error: false,
+		Data:  book,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
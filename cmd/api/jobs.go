@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// startJobs starts the background jobs. They stop when ctx is done, after
+// finishing the run in progress; app.jobs.Wait waits for that.
+func (app *application) startJobs(ctx context.Context) {
+	app.every(ctx, "purge_trash", app.config.trash.purgeInterval, app.purgeTrash)
+	app.every(ctx, "expire_holds", app.config.holds.expiryInterval, app.expireHolds)
+	app.every(ctx, "charge_overdue", app.config.fines.interval, app.chargeOverdue)
+}
+
+// every runs job each interval until ctx is done. A run that fails, or
+// panics, is logged and the job carries on at the next tick.
+func (app *application) every(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	app.jobs.Add(1)
+	go func() {
+		defer app.jobs.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// a run in progress at shutdown is allowed to finish
+				app.runJob(context.WithoutCancel(ctx), name, job)
+			}
+		}
+	}()
+}
+
+// runJob runs job once in a span of its own, recording the result.
+func (app *application) runJob(ctx context.Context, name string, job func(context.Context) error) {
+	ctx, span := tracer.Start(ctx, "job."+name)
+	defer span.End()
+
+	logger := app.loggerFor(ctx).With("job", name)
+	start := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return job(ctx)
+	}()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		app.metrics.jobRuns.WithLabelValues(name, "error").Inc()
+		logger.Error("job failed", "error", err, "duration", time.Since(start))
+		return
+	}
+
+	app.metrics.jobRuns.WithLabelValues(name, "ok").Inc()
+	logger.Debug("job finished", "duration", time.Since(start))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey string
+
+const requestInfoKey = contextKey("request")
+
+// requestInfo is shared by every handler serving a request. Middleware further
+// down the chain fills in the user once one has authenticated, so the access
+// log, written last, can report it.
+type requestInfo struct {
+	id   string
+	user *data.User
+}
+
+// requestIDPattern is what we accept from a client supplied X-Request-ID; any
+// other value is replaced so it can't forge log lines.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// newLogger logs JSON in production and human readable text everywhere else.
+func newLogger(env string, w io.Writer) *slog.Logger {
+	if env == "production" {
+		return slog.New(slog.NewJSONHandler(w, nil))
+	}
+	return slog.New(slog.NewTextHandler(w, nil))
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, ok := ctx.Value(requestInfoKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// contextGetUser returns the authenticated user of the request, or nil.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	return requestInfoFrom(r.Context()).user
+}
+
+// loggerFor returns the application logger annotated with the request id,
+// trace and user of the request ctx belongs to.
+func (app *application) loggerFor(ctx context.Context) *slog.Logger {
+	info := requestInfoFrom(ctx)
+
+	logger := app.logger
+	if info.id != "" {
+		logger = logger.With("request_id", info.id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	if info.user != nil {
+		logger = logger.With("user_id", info.user.ID)
+	}
+	return logger
+}
+
+func (app *application) logError(r *http.Request, err error) {
+	app.loggerFor(r.Context()).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+}
+
+// RequestIDMiddleware gives every request an id, taken from X-Request-ID when
+// the client sent a usable one, and echoes it in the response.
+func (app *application) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey, &requestInfo{id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// AccessLogMiddleware logs one line per request once it has been served.
+func (app *application) AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr", r.RemoteAddr,
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			attrs = append(attrs, "route", rctx.RoutePattern())
+		}
+
+		app.loggerFor(r.Context()).Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// RecoverMiddleware turns a panicking handler into a 500 response and logs the
+// panic, with its stack, against the request.
+func (app *application) RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			w.Header().Set("Connection", "close")
+			app.errorResponse(w, r, fmt.Errorf("panic: %v\n%s", rec, debug.Stack()))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/mailer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mailTimeout bounds sending one message, connecting included.
+const mailTimeout = 30 * time.Second
+
+// sendMail sends msg in the background, so a slow or unreachable mail server
+// doesn't hold up the request or job it's sent from. kind names the message
+// in metrics and logs. Failures are logged and not retried.
+func (app *application) sendMail(ctx context.Context, kind string, msg mailer.Message) {
+	logger := app.loggerFor(ctx).With("mail", kind)
+	if app.mailer == nil {
+		logger.Debug("mail not sent, no SMTP server is configured")
+		return
+	}
+
+	app.jobs.Add(1)
+	go func() {
+		defer app.jobs.Done()
+
+		// the request is likely over by the time the server answers
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		ctx, span := tracer.Start(ctx, "mail.send", trace.WithAttributes(attribute.String("mail.kind", kind)))
+		defer span.End()
+
+		err := app.mailer.Send(ctx, msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			app.metrics.mailSent.WithLabelValues(kind, "error").Inc()
+			logger.Error("mail not sent", "error", err)
+			return
+		}
+
+		app.metrics.mailSent.WithLabelValues(kind, "ok").Inc()
+		logger.Debug("mail sent")
+	}()
+}
@@ -1,63 +1,228 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/jumaniyozov/gobook/internal/data"
 	"github.com/jumaniyozov/gobook/internal/driver"
+	"github.com/jumaniyozov/gobook/internal/mailer"
+	"github.com/jumaniyozov/gobook/internal/ratelimit"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-type config struct {
-	port int // what port do we want the web server to listen on
-}
-
 type application struct {
 	config      config
-	infoLog     *log.Logger
-	errorLog    *log.Logger
+	logger      *slog.Logger
+	metrics     *metrics
+	limiter     ratelimit.Store
 	models      data.Models
 	environment string
+	started     time.Time
+	jobs        sync.WaitGroup // background jobs and mail, see jobs.go and mail.go
+	mailer      *mailer.Mailer // nil when no SMTP server is configured
+
+	configSummary map[string]any // resolved configuration, secrets redacted
 }
 
 func main() {
 	var cfg config
-	cfg.port = 8081
+	loader := newConfigLoader(&cfg)
+	err := loader.load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if loader.printOnly {
+		err = loader.print(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	logger := newLogger(cfg.env, os.Stdout)
 
-	dsn := os.Getenv("DSN")
-	environment := os.Getenv("ENV")
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.Error("cannot set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	db, err := driver.ConnectPostgres(dsn)
+	db, err := driver.ConnectPostgres(cfg.dsn, driver.Options{
+		MaxOpenConns:    cfg.db.maxOpenConns,
+		MaxIdleConns:    cfg.db.maxIdleConns,
+		ConnMaxLifetime: cfg.db.connMaxLifetime,
+	})
 	if err != nil {
-		log.Fatal("Cannot connect to database")
+		logger.Error("cannot connect to database", "error", err)
+		os.Exit(1)
 	}
-	defer db.SQL.Close()
+	logger.Info("connected to database")
+
+	models := data.New(db.SQL, data.Timeouts{
+		Read:  cfg.db.readTimeout,
+		Write: cfg.db.writeTimeout,
+	})
 
 	app := &application{
 		config:      cfg,
-		infoLog:     infoLog,
-		errorLog:    errorLog,
-		models:      data.New(db.SQL),
-		environment: environment,
+		logger:      logger,
+		metrics:     newMetrics(db.SQL, models, logger),
+		models:      models,
+		environment: cfg.env,
+		started:     time.Now(),
+
+		configSummary: loader.summary(),
+	}
+
+	if cfg.mail.smtp != "" {
+		app.mailer, err = mailer.New(cfg.mail.smtp, cfg.mail.sender)
+		if err != nil {
+			logger.Error("cannot set up mail", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	app.limiter = ratelimit.NewMemoryStore()
+	if cfg.ratelimit.store == "postgres" {
+		app.limiter = ratelimit.NewPostgresStore(db.SQL)
+	}
+
+	// a stale schema is worth knowing about at once, but /readyz is what keeps
+	// traffic away until it's fixed
+	if _, err := app.checkMigrations(context.Background()); err != nil {
+		logger.Warn("database schema is not current, run make migrate", "error", err)
 	}
 
 	err = app.serve()
 	if err != nil {
-		log.Fatal(err)
+		db.SQL.Close()
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("closing database connections")
+	err = db.SQL.Close()
+	if err != nil {
+		logger.Error(err.Error())
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.server.shutdownTimeout)
+	defer cancel()
+	err = shutdownTracing(ctx)
+	if err != nil {
+		logger.Error("spans not flushed", "error", err)
+	}
+	logger.Info("shutdown complete")
 }
 
 func (app *application) serve() error {
-	app.infoLog.Println("API listening on port", app.config.port)
+	handler := app.routes()
+
+	// every route must be described in docs/openapi.yaml, which
+	// TestRoutesDocumented enforces; refuse to start in development too, and
+	// log in production in case a build skipped the tests
+	missing, err := undocumentedRoutes(handler.(chi.Routes))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		if app.environment == "development" {
+			return fmt.Errorf("routes missing from openapi.yaml: %s", strings.Join(missing, ", "))
+		}
+		app.logger.Error("routes missing from openapi.yaml", "routes", missing)
+	}
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
-		Handler: app.routes(),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           handler,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		IdleTimeout:       app.config.server.idleTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// metrics are served on their own port so they can be kept off the public network
+	var metricsSrv *http.Server
+	if app.config.metrics.port != 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", app.metrics.handler())
+
+		metricsSrv = &http.Server{
+			Addr:              fmt.Sprintf(":%d", app.config.metrics.port),
+			Handler:           metricsMux,
+			ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+			WriteTimeout:      app.config.server.writeTimeout,
+			ErrorLog:          srv.ErrorLog,
+		}
+
+		go func() {
+			app.logger.Info("metrics listening", "port", app.config.metrics.port)
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("metrics listener stopped", "error", err)
+			}
+		}()
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	app.startJobs(jobsCtx)
+
+	// on SIGINT or SIGTERM, stop accepting connections and give in-flight
+	// requests until the shutdown timeout to complete, then let any job that
+	// is running finish
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		app.logger.Info("draining in-flight requests", "signal", sig.String(), "timeout", app.config.server.shutdownTimeout.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			metricsSrv.Close()
+		}
+
+		app.logger.Info("stopping background jobs")
+		stopJobs()
+		app.jobs.Wait()
+
+		shutdownErr <- err
+	}()
+
+	app.logger.Info("API listening", "port", app.config.port, "env", app.environment, "version", buildVersion())
+
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		return fmt.Errorf("graceful shutdown did not finish: %w", err)
 	}
 
-	return srv.ListenAndServe()
+	app.logger.Info("all requests completed, server stopped")
+	return nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds every Prometheus collector the API exports. They're kept on
+// their own registry, served by a listener separate from the API.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	inFlight         prometheus.Gauge
+	logins           *prometheus.CounterVec
+	tokenValidations *prometheus.CounterVec
+	coverBytes       prometheus.Histogram
+	rateLimited      *prometheus.CounterVec
+	jobRuns          *prometheus.CounterVec
+	mailSent         *prometheus.CounterVec
+}
+
+func newMetrics(db *sql.DB, models data.Models, logger *slog.Logger) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_http_requests_total",
+			Help: "HTTP requests served, by route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gobook_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gobook_http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_logins_total",
+			Help: "Login attempts, by result.",
+		}, []string{"result"}),
+		tokenValidations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_token_validations_total",
+			Help: "Authentication token checks, by result.",
+		}, []string{"result"}),
+		coverBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gobook_cover_upload_bytes",
+			Help:    "Size of uploaded book covers.",
+			Buckets: prometheus.ExponentialBuckets(16<<10, 2, 9), // 16KiB to 4MiB
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_rate_limited_total",
+			Help: "Requests refused for exceeding a rate limit, by route group.",
+		}, []string{"group"}),
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_job_runs_total",
+			Help: "Runs of background jobs, by job and result.",
+		}, []string{"job", "result"}),
+		mailSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobook_mail_sent_total",
+			Help: "Notification emails, by kind and result.",
+		}, []string{"kind", "result"}),
+	}
+
+	// totals are counted when scraped rather than tracked on every write
+	total := func(name, help string, count func(context.Context) (int, error)) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, func() float64 {
+			n, err := count(context.Background())
+			if err != nil {
+				logger.Error("metrics: counting failed", "metric", name, "error", err)
+				return math.NaN()
+			}
+			return float64(n)
+		})
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "gobook"),
+		m.requests,
+		m.requestDuration,
+		m.inFlight,
+		m.logins,
+		m.tokenValidations,
+		m.coverBytes,
+		m.rateLimited,
+		m.jobRuns,
+		m.mailSent,
+		total("gobook_books", "Books in the catalogue.", models.Book.Count),
+		total("gobook_users", "Registered users.", models.User.Count),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// MetricsMiddleware records the count and latency of requests by chi route
+// pattern, so /v1/books/1 and /v1/books/2 are counted together.
+func (app *application) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// unmatched paths share one label so scanners can't blow up cardinality
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		app.metrics.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		app.metrics.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// tokenResult labels a token check: "valid", or the code of the error it failed with.
+func tokenResult(err error) string {
+	if err == nil {
+		return "valid"
+	}
+
+	var dataErr *data.Error
+	if errors.As(err, &dataErr) && dataErr.Code != "" {
+		return dataErr.Code
+	}
+	return "error"
+}
@@ -1,19 +1,52 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+// When the unversioned routes were deprecated in favour of /v1, and when they
+// will be removed.
+var (
+	legacyDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := app.models.Token.AuthenticateToken(r)
+		user, err := app.models.Token.AuthenticateToken(r)
+		app.metrics.tokenValidations.WithLabelValues(tokenResult(err)).Inc()
 		if err != nil {
-			payload := jsonResponse{
-				Error:   true,
-				Message: "invalid authentication credentials",
-			}
+			app.errorResponse(w, r, err)
+			return
+		}
+		requestInfoFrom(r.Context()).user = user
+		next.ServeHTTP(w, r)
+	})
+}
 
-			_ = app.writeJSON(w, http.StatusUnauthorized, payload)
+// StaffMiddleware refuses requests from anyone but staff. It goes after
+// AuthTokenMiddleware, which identifies the user.
+func (app *application) StaffMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := app.contextGetUser(r); user == nil || !user.Staff {
+			app.errorResponse(w, r, &data.Error{Kind: data.ErrForbidden, Code: "staff_only", Message: "only staff can use this route"})
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// DeprecatedMiddleware announces the deprecation and removal date of a route
+// with the Deprecation (RFC 9745) and Sunset (RFC 8594) headers.
+func (app *application) DeprecatedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecated.Unix()))
+		w.Header().Set("Sunset", legacySunset.Format(http.TimeFormat))
+		w.Header().Add("Link", `</docs>; rel="deprecation"; type="text/html"`)
+		next.ServeHTTP(w, r)
+	})
+}
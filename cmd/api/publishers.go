@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Publishers and their imprints are managed under /v1/admin/publishers. Books
+// link to them with publisher_id and imprint_id, see bookPublisher.
+
+// nameInput is the only editable field of a publisher or imprint.
+type nameInput struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+func (app *application) ListPublishers(w http.ResponseWriter, r *http.Request) {
+	all, err := app.models.Publisher.All(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"publishers": all},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) ShowPublisher(w http.ResponseWriter, r *http.Request) {
+	publisherID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusOK, "success", publisherID)
+}
+
+func (app *application) CreatePublisher(w http.ResponseWriter, r *http.Request) {
+	input, err := app.readNameInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.models.Publisher.Insert(r.Context(), data.Publisher{Name: input.Name})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusCreated, "publisher created", id)
+}
+
+func (app *application) UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+	publisherID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input, err := app.readNameInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	publisher := data.Publisher{ID: publisherID, Name: input.Name}
+	err = publisher.Update(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusOK, "Changes saved", publisherID)
+}
+
+// DeletePublisher deletes a publisher and its imprints. Publishers of books
+// can't be deleted.
+func (app *application) DeletePublisher(w http.ResponseWriter, r *http.Request) {
+	publisherID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Publisher.DeleteByID(r.Context(), publisherID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Publisher deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) CreateImprint(w http.ResponseWriter, r *http.Request) {
+	publisherID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input, err := app.readNameInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// make sure the publisher exists, so the imprint isn't reported missing
+	_, err = app.models.Publisher.GetOne(r.Context(), publisherID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Publisher.InsertImprint(r.Context(), data.Imprint{PublisherID: publisherID, Name: input.Name})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusCreated, "imprint created", publisherID)
+}
+
+func (app *application) UpdateImprint(w http.ResponseWriter, r *http.Request) {
+	publisherID, imprintID, err := app.readImprintParams(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input, err := app.readNameInput(w, r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Publisher.UpdateImprint(r.Context(), data.Imprint{ID: imprintID, PublisherID: publisherID, Name: input.Name})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusOK, "Changes saved", publisherID)
+}
+
+// DeleteImprint deletes an imprint. Imprints of books can't be deleted.
+func (app *application) DeleteImprint(w http.ResponseWriter, r *http.Request) {
+	publisherID, imprintID, err := app.readImprintParams(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Publisher.DeleteImprint(r.Context(), publisherID, imprintID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writePublisher(w, r, http.StatusOK, "Imprint deleted", publisherID)
+}
+
+// readNameInput reads and validates the name of a publisher or imprint.
+func (app *application) readNameInput(w http.ResponseWriter, r *http.Request) (nameInput, error) {
+	var input nameInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return input, err
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	if !v.Valid() {
+		return input, data.FieldErrors(v.Errors)
+	}
+
+	return input, nil
+}
+
+// readImprintParams returns the publisher and imprint ids of a route under
+// /admin/publishers/{id}/imprints/{imprint}.
+func (app *application) readImprintParams(r *http.Request) (int, int, error) {
+	publisherID, err := app.readIDParam(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imprintID, err := strconv.Atoi(chi.URLParam(r, "imprint"))
+	if err != nil || imprintID < 1 {
+		return 0, 0, badRequest("invalid_id", "imprint must be a positive integer", err)
+	}
+
+	return publisherID, imprintID, nil
+}
+
+// writePublisher responds with the publisher with the given id and its
+// imprints.
+func (app *application) writePublisher(w http.ResponseWriter, r *http.Request, status int, message string, id int) {
+	publisher, err := app.models.Publisher.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var headers []http.Header
+	if status == http.StatusCreated {
+		h := make(http.Header)
+		h.Set("Location", fmt.Sprintf("/v1/admin/publishers/%d", id))
+		headers = append(headers, h)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    envelope{"publisher": publisher},
+	}
+
+	err = app.writeJSON(w, status, payload, headers...)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jumaniyozov/gobook/internal/ratelimit"
+)
+
+// errRateLimited is the kind used when a client has used up its requests.
+var errRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitMiddleware gives every client of a route group its own token bucket.
+// Buckets are separate per group, so exhausting sign in attempts doesn't stop
+// a client browsing books. When the store can't be reached, requests are let
+// through rather than failing the whole API.
+func (app *application) RateLimitMiddleware(group string, limit rateLimit) func(http.Handler) http.Handler {
+	l := ratelimit.PerMinute(limit.perMinute, limit.burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.config.ratelimit.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := app.limiter.Take(r.Context(), group+":"+app.clientKey(r), l)
+			if err != nil {
+				app.loggerFor(r.Context()).Error("rate limit not checked", "group", group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=60;burst=%d", limit.perMinute, limit.burst))
+
+			if !res.Allowed {
+				app.metrics.rateLimited.WithLabelValues(group).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				app.errorResponse(w, r, errRateLimited)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies who is making the request: the user the token was
+// checked for by AuthTokenMiddleware, else the client's address. Unchecked
+// bearer tokens aren't used, as a client could send a new one every time.
+func (app *application) clientKey(r *http.Request) string {
+	if user := app.contextGetUser(r); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	// the proxy appends the address it saw, so the last entry is the only one
+	// the client can't have made up
+	if app.config.ratelimit.trustForwarded {
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+			return "ip:" + ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded []string
+		bearer    string
+		user      *data.User
+		want      string
+	}{
+		{"address", false, nil, "", nil, "ip:192.0.2.1"},
+		{"forwarded ignored unless trusted", false, []string{"198.51.100.7"}, "", nil, "ip:192.0.2.1"},
+		{"forwarded", true, []string{"198.51.100.7"}, "", nil, "ip:198.51.100.7"},
+		{"last forwarded entry", true, []string{"203.0.113.9, 198.51.100.7"}, "", nil, "ip:198.51.100.7"},
+		{"last of several headers", true, []string{"203.0.113.9", "10.0.0.1, 198.51.100.7"}, "", nil, "ip:198.51.100.7"},
+		{"forwarded ipv6", true, []string{"2001:db8::1"}, "", nil, "ip:2001:db8::1"},
+		{"forwarded garbage", true, []string{"203.0.113.9, not-an-ip"}, "", nil, "ip:192.0.2.1"},
+		{"forwarded empty entry", true, []string{"203.0.113.9,"}, "", nil, "ip:192.0.2.1"},
+		{"unchecked token ignored", false, nil, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", nil, "ip:192.0.2.1"},
+		{"authenticated user", true, []string{"198.51.100.7"}, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", &data.User{ID: 42}, "user:42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			app.config.ratelimit.trustForwarded = tt.trust
+
+			r := httptest.NewRequest("GET", "/v1/books", nil)
+			r.RemoteAddr = "192.0.2.1:54321"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, &requestInfo{user: tt.user}))
+
+			if got := app.clientKey(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Every save of a book records a revision, see data.BookRevision. These
+// handlers list them, compare any two and revert a book to one.
+
+func (app *application) ListBookRevisions(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// trashed books have no visible history
+	_, err = app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	revisions, err := app.models.BookRevision.All(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"revisions": revisions},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// DiffBookRevisions lists the fields that changed between the revisions at
+// versions ?from= and ?to=, which defaults to the current version.
+func (app *application) DiffBookRevisions(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	book, err := app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	from, err := strconv.Atoi(qs.Get("from"))
+	v.Check(err == nil && from > 0, "from", "must be a version of the book")
+
+	to := book.Version
+	if qs.Has("to") {
+		to, err = strconv.Atoi(qs.Get("to"))
+		v.Check(err == nil && to > 0, "to", "must be a version of the book")
+	}
+
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	older, err := app.models.BookRevision.Get(r.Context(), bookID, from)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	newer, err := app.models.BookRevision.Get(r.Context(), bookID, to)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"from": older, "to": newer, "changes": older.Diff(newer)},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// RevertBook saves a book with the fields, genres and cover of an earlier
+// revision. The revert is a save like any other, so it's recorded as a new
+// revision and can itself be reverted.
+func (app *application) RevertBook(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version < 1 {
+		app.errorResponse(w, r, badRequest("invalid_version", "version must be a positive integer", err))
+		return
+	}
+
+	book, err := app.models.Book.GetOneById(r.Context(), bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := ifMatch(r, bookEditTag(book), book); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	rev, err := app.models.BookRevision.Get(r.Context(), bookID, version)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	input := bookInput{
+		Title:           rev.Title,
+		AuthorID:        rev.AuthorID,
+		PublicationYear: rev.PublicationYear,
+		Description:     rev.Description,
+		GenreIDs:        rev.GenreIDs,
+		Version:         book.Version,
+		ISBN:            &rev.ISBN13,
+		OCLC:            &rev.OCLC,
+		LCCN:            &rev.LCCN,
+		DOI:             &rev.DOI,
+		Format:          rev.Format,
+		PageCount:       rev.PageCount,
+		Language:        rev.Language,
+	}
+
+	// the publisher or imprint of the revision may have been deleted since,
+	// leaving the book without one
+	if rev.PublisherID != 0 {
+		_, err := app.models.Publisher.GetOne(r.Context(), rev.PublisherID)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if err == nil {
+			input.PublisherID = rev.PublisherID
+		}
+	}
+	if rev.ImprintID != 0 && input.PublisherID != 0 {
+		imprint, err := app.models.Publisher.Imprint(r.Context(), rev.ImprintID)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if err == nil && imprint.PublisherID == input.PublisherID {
+			input.ImprintID = rev.ImprintID
+		}
+	}
+
+	// the work the revision was an edition of may be gone since, leaving the
+	// book in the work it's in now
+	if rev.WorkID != 0 && rev.WorkID != book.WorkID {
+		exists, err := app.models.Work.Exists(r.Context(), rev.WorkID)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if exists {
+			input.WorkID = rev.WorkID
+		}
+	}
+
+	// the contributors decide the first author; revisions recorded before
+	// there were contributors only have the author
+	for _, c := range rev.Contributors {
+		input.Contributors = append(input.Contributors, contributorInput{AuthorID: c.AuthorID, Role: c.Role})
+	}
+	if input.Contributors != nil {
+		input.AuthorID = 0
+	}
+
+	// revisions from before covers were tracked leave the cover as it is
+	if rev.CoverKey != "" {
+		input.cover, err = os.ReadFile(coverRevisionPath(rev.CoverKey))
+		if errors.Is(err, os.ErrNotExist) {
+			app.loggerFor(r.Context()).Warn("cover of revision is missing", "book_id", bookID, "version", version)
+		} else if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeSavedBook(w, r, bookID, input)
+}
@@ -2,47 +2,164 @@ package main
 
 import (
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
-	mux.Use(middleware.Recoverer)
+	mux.Use(app.RequestIDMiddleware)
+	mux.Use(app.TracingMiddleware)
+	mux.Use(app.AccessLogMiddleware)
+	mux.Use(app.MetricsMiddleware)
+	mux.Use(app.RecoverMiddleware)
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
+		AllowedOrigins:   app.config.cors.allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID", "Traceparent", "Tracestate", "If-Match", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"Link", "Location", "ETag", "Last-Modified", "Deprecation", "Sunset", "X-Request-ID", "X-Trace-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
+		AllowCredentials: app.config.cors.allowCredentials,
+		MaxAge:           app.config.cors.maxAge,
 	}))
 
-	mux.Post("/users/login", app.Login)
-	mux.Post("/users/logout", app.Logout)
-	mux.Post("/users/signup", app.EditUser)
+	// each group of routes has its own rate limit, see RateLimitMiddleware
+	limitAuth := app.RateLimitMiddleware("auth", app.config.ratelimit.auth)
+	limitPublic := app.RateLimitMiddleware("public", app.config.ratelimit.public)
+	limitUser := app.RateLimitMiddleware("user", app.config.ratelimit.user)
 
-	mux.Get("/books", app.AllBooks)
-	mux.Get("/books/{slug}", app.OneBook)
+	// and its own caching policy, see cache.go
+	publicCache := app.CacheControlMiddleware(app.publicCachePolicy())
+	privateCache := app.CacheControlMiddleware(cachePrivate)
+	noStore := app.CacheControlMiddleware(cacheNoStore)
 
-	mux.Post("/validate-token", app.ValidateToken)
+	mux.Route("/v1", func(mux chi.Router) {
+		mux.Group(func(mux chi.Router) {
+			mux.Use(limitAuth, noStore)
 
-	mux.Route("/admin", func(mux chi.Router) {
-		mux.Use(app.AuthTokenMiddleware)
+			mux.Post("/tokens", app.Login)
+			mux.Post("/tokens/validate", app.ValidateToken)
+			mux.Post("/users", app.CreateUser)
+		})
 
-		mux.Get("/users/all", app.GetAllUsers)
-		mux.Post("/users/save", app.EditUser)
-		mux.Get("/users/get/{id}", app.GetUser)
-		mux.Post("/users/delete", app.DeleteUser)
-		mux.Post("/users/log-user-out/{id}", app.LogUserOutAndSetInactive)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(limitPublic, publicCache)
 
-		mux.Get("/authors/all", app.AuthorsAll)
-		mux.Post("/books/save", app.EditBok)
-		mux.Get("/books/{id}", app.BookByID)
-		mux.Delete("/books/{id}", app.DeleteBook)
+			mux.Get("/books", app.AllBooks)
+			mux.Get("/books/{id}", app.BookByID)
+			mux.Get("/books/slug/{slug}", app.OneBook)
+			mux.Get("/books/isbn/{isbn}", app.BookByISBN)
+			mux.Get("/books/lookup", app.LookupBook)
+			mux.Get("/authors", app.ListAuthors)
+			mux.Get("/authors/{id}", app.ShowAuthor)
+			mux.Get("/works/{id}", app.ShowWork)
+			mux.Get("/series", app.ListSeries)
+			mux.Get("/series/{slug}", app.ShowSeries)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.AuthTokenMiddleware, limitUser, privateCache)
+
+			mux.Delete("/tokens", app.RevokeToken)
+
+			mux.Get("/users", app.GetAllUsers)
+			mux.Get("/users/{id}", app.ShowUser)
+			mux.Put("/users/{id}", app.UpdateUser)
+			mux.Patch("/users/{id}", app.PatchUser)
+			mux.Delete("/users/{id}", app.DeleteUserByID)
+			mux.Post("/users/{id}/logout", app.LogUserOutAndSetInactive)
+
+			mux.Post("/books", app.CreateBook)
+			mux.Put("/books/{id}", app.UpdateBook)
+			mux.Patch("/books/{id}", app.PatchBook)
+			mux.Delete("/books/{id}", app.DeleteBook)
+			mux.Get("/books/{id}/revisions", app.ListBookRevisions)
+			mux.Get("/books/{id}/revisions/diff", app.DiffBookRevisions)
+			mux.Post("/books/{id}/revisions/{version}/revert", app.RevertBook)
+
+			mux.Get("/books/{id}/copies", app.ListCopies)
+			mux.Post("/books/{id}/copies", app.CreateCopy)
+			mux.Get("/copies/{id}", app.ShowCopy)
+			mux.Put("/copies/{id}", app.UpdateCopy)
+
+			mux.Get("/loans", app.ListLoans)
+			mux.Post("/loans", app.Checkout)
+			mux.Get("/loans/{id}", app.ShowLoan)
+			mux.With(app.StaffMiddleware).Post("/loans/{id}/return", app.ReturnLoan)
+			mux.Post("/loans/{id}/renew", app.RenewLoan)
+
+			mux.Get("/holds", app.ListHolds)
+			mux.Post("/books/{id}/holds", app.PlaceHold)
+			mux.Get("/holds/{id}", app.ShowHold)
+			mux.Post("/holds/{id}/cancel", app.CancelHold)
+
+			mux.Get("/users/{id}/ledger", app.ShowLedger)
+			mux.Post("/users/{id}/ledger", app.RecordLedgerEntry)
+
+			mux.Delete("/authors/{id}", app.DeleteAuthor)
+
+			mux.Put("/works/{id}", app.UpdateWork)
+
+			mux.Post("/series", app.CreateSeries)
+			mux.Put("/series/{slug}", app.UpdateSeries)
+			mux.Delete("/series/{slug}", app.DeleteSeries)
+			mux.Put("/series/{slug}/books/{id}", app.SetSeriesBook)
+			mux.Delete("/series/{slug}/books/{id}", app.RemoveSeriesBook)
+
+			mux.Route("/admin", func(mux chi.Router) {
+				mux.Use(app.StaffMiddleware)
+
+				mux.Get("/publishers", app.ListPublishers)
+				mux.Post("/publishers", app.CreatePublisher)
+				mux.Get("/publishers/{id}", app.ShowPublisher)
+				mux.Put("/publishers/{id}", app.UpdatePublisher)
+				mux.Delete("/publishers/{id}", app.DeletePublisher)
+				mux.Post("/publishers/{id}/imprints", app.CreateImprint)
+				mux.Put("/publishers/{id}/imprints/{imprint}", app.UpdateImprint)
+				mux.Delete("/publishers/{id}/imprints/{imprint}", app.DeleteImprint)
+
+				mux.Get("/diagnostics", app.Diagnostics)
+				mux.Get("/trash", app.ListTrash)
+				mux.Post("/trash/{type}/{id}/restore", app.RestoreFromTrash)
+			})
+		})
+	})
+
+	// the original, unversioned routes are kept for existing clients until
+	// legacySunset
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.DeprecatedMiddleware)
+
+		mux.With(limitAuth, noStore).Post("/users/login", app.Login)
+		mux.With(noStore).Post("/users/logout", app.Logout)
+		mux.With(limitAuth, noStore).Post("/users/signup", app.Signup)
+
+		mux.With(limitPublic, publicCache).Get("/books", app.AllBooks)
+		mux.With(limitPublic, publicCache).Get("/books/{slug}", app.OneBook)
+
+		mux.With(limitAuth, noStore).Post("/validate-token", app.ValidateToken)
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(app.AuthTokenMiddleware, app.StaffMiddleware, limitUser, privateCache)
+
+			mux.Get("/users/all", app.GetAllUsers)
+			mux.Post("/users/save", app.EditUser)
+			mux.Get("/users/get/{id}", app.GetUser)
+			mux.Post("/users/delete", app.DeleteUser)
+			mux.Post("/users/log-user-out/{id}", app.LogUserOutAndSetInactive)
+
+			mux.Get("/authors/all", app.AuthorsAll)
+			mux.Post("/books/save", app.EditBok)
+			mux.Get("/books/{id}", app.BookByID)
+			mux.Delete("/books/{id}", app.DeleteBook)
+		})
 	})
 
+	mux.With(noStore).Get("/healthz", app.Healthz)
+	mux.With(noStore).Get("/readyz", app.Readyz)
+
+	mux.Get("/openapi.yaml", app.OpenAPISpec)
+	mux.Get("/docs", app.Docs)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Series are looked up by slug, both to read them and to change them, and list
+// their books in reading order. Books show their place in each series they're
+// in, see data.BookSeries.
+
+// seriesInput holds the editable fields of a series.
+type seriesInput struct {
+	Name        string `json:"name" validate:"required,max=255"`
+	Slug        string `json:"slug" validate:"max=255,slug"` // made from the name when left out
+	Description string `json:"description"`
+}
+
+// ListSeries lists every series, without their books.
+func (app *application) ListSeries(w http.ResponseWriter, r *http.Request) {
+	all, err := app.models.Series.All(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"series": all},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// ShowSeries returns a series with its books in reading order.
+func (app *application) ShowSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := app.models.Series.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"series": series},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) CreateSeries(w http.ResponseWriter, r *http.Request) {
+	var input seriesInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	id, err := app.models.Series.Insert(r.Context(), data.Series{Name: input.Name, Slug: input.Slug, Description: input.Description})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	series, err := app.models.Series.GetByID(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/v1/series/"+series.Slug)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "series created",
+		Data:    envelope{"series": series},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload, headers)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// UpdateSeries replaces the name and description of a series, and its slug
+// when one is sent.
+func (app *application) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := app.models.Series.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var input seriesInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	series.Name = input.Name
+	series.Slug = input.Slug
+	series.Description = input.Description
+	err = series.Update(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeSavedSeries(w, r, series.ID)
+}
+
+// DeleteSeries deletes a series. Its books are kept.
+func (app *application) DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := app.models.Series.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Series.DeleteByID(r.Context(), series.ID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Series deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// SetSeriesBook adds a book to a series at the position sent, or moves it
+// there if it's already in the series.
+func (app *application) SetSeriesBook(w http.ResponseWriter, r *http.Request) {
+	series, err := app.models.Series.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Position *float64 `json:"position"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Position == nil {
+		v.AddError("position", "must be provided")
+	} else {
+		position := *input.Position
+		v.Check(position >= 0 && position < 10000, "position", "must be at least 0 and less than 10000")
+		v.Check(math.Round(position*100) == position*100, "position", "must have at most two decimal places")
+	}
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	err = app.models.Series.SetBook(r.Context(), series.ID, bookID, *input.Position)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeSavedSeries(w, r, series.ID)
+}
+
+// RemoveSeriesBook takes a book out of a series.
+func (app *application) RemoveSeriesBook(w http.ResponseWriter, r *http.Request) {
+	series, err := app.models.Series.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Series.RemoveBook(r.Context(), series.ID, bookID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeSavedSeries(w, r, series.ID)
+}
+
+// writeSavedSeries responds with the series with the given id, as it is after
+// a change.
+func (app *application) writeSavedSeries(w http.ResponseWriter, r *http.Request, id int) {
+	series, err := app.models.Series.GetByID(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+		Data:    envelope{"series": series},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans the API starts itself; HTTP and SQL spans come from
+// otelhttp and otelsql.
+var tracer = otel.Tracer("github.com/jumaniyozov/gobook/cmd/api")
+
+// setupTracing installs the global tracer provider and propagator. The returned
+// function flushes spans that haven't been exported yet.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.tracing.exporter {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.tracing.otlpEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.tracing.otlpEndpoint), otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("gobook"),
+		semconv.DeploymentEnvironment(cfg.env),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// TracingMiddleware starts a span for every request, continuing the caller's
+// trace when it sent a traceparent header. Spans are named after the chi route
+// pattern once the request has been routed, and the trace id is returned in
+// X-Trace-ID.
+func (app *application) TracingMiddleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			w.Header().Set("X-Trace-ID", sc.TraceID().String())
+		}
+
+		next.ServeHTTP(w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route := rctx.RoutePattern()
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+	})
+
+	return otelhttp.NewHandler(named, "http.request")
+}
+
+// traceFileOp runs op, which touches the cover storage, in a span of its own.
+func traceFileOp(ctx context.Context, name, path string, op func() error) error {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(semconv.FilePath(path)))
+	defer span.End()
+
+	err := op()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
+)
+
+// Deleted books, authors and users go to the trash. They can be listed and
+// restored until they've been there for trash.retention, when purgeTrash
+// deletes them for good.
+
+// ListTrash lists what's in the trash, optionally only records of ?type=.
+func (app *application) ListTrash(w http.ResponseWriter, r *http.Request) {
+	typ := r.URL.Query().Get("type")
+	if typ != "" && !slices.Contains(data.TrashTypes, typ) {
+		app.errorResponse(w, r, invalidTrashType())
+		return
+	}
+
+	items, err := app.models.Trash.List(r.Context(), typ)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"items": items, "retention": app.config.trash.retention.String()},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// RestoreFromTrash takes a book, author or user out of the trash.
+func (app *application) RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	typ := chi.URLParam(r, "type")
+	if !slices.Contains(data.TrashTypes, typ) {
+		app.errorResponse(w, r, invalidTrashType())
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Trash.Restore(r.Context(), typ, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: typ + " restored",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func invalidTrashType() error {
+	return badRequest("invalid_type", "type must be one of "+strings.Join(data.TrashTypes, ", "), nil)
+}
+
+// purgeTrash deletes for good whatever has been in the trash for longer than
+// the retention period, along with the covers of purged books.
+func (app *application) purgeTrash(ctx context.Context) error {
+	purged, err := app.models.Trash.Purge(ctx, time.Now().Add(-app.config.trash.retention))
+	if err != nil {
+		return err
+	}
+
+	for _, slug := range purged.BookSlugs {
+		err := traceFileOp(ctx, "cover.remove", coverPath(slug), func() error {
+			err := os.Remove(coverPath(slug))
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			app.loggerFor(ctx).Error("cover not removed", "error", err)
+		}
+	}
+
+	if purged.Books+purged.Authors+purged.Users+purged.Kept > 0 {
+		app.loggerFor(ctx).Info("trash purged", "books", purged.Books, "authors", purged.Authors, "users", purged.Users, "kept", purged.Kept)
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jumaniyozov/gobook/internal/data"
 )
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
@@ -14,12 +17,12 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data an
 	dec := json.NewDecoder(r.Body)
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		return badRequest("invalid_json", "invalid json supplied, or json missing entirely", err)
 	}
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must have only a single json value")
+		return badRequest("invalid_json", "body must have only a single json value", nil)
 	}
 
 	return nil
@@ -37,7 +40,9 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -47,18 +52,46 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
-	statusCode := http.StatusBadRequest
-	if len(status) > 0 {
-		statusCode = status[0]
+// actFor checks the requester may do action for the user with the given id:
+// that's themselves, or anyone when they're staff.
+func (app *application) actFor(r *http.Request, userID int, action string) error {
+	user := app.contextGetUser(r)
+	if user != nil && (user.ID == userID || user.Staff) {
+		return nil
+	}
+	return &data.Error{Kind: data.ErrForbidden, Code: "staff_only", Message: "only staff can " + action + " for other users"}
+}
+
+// listFor returns the user whose records a listing may show, when it was asked
+// for those of userID, or everyone's when that's zero. Staff may list anyone's;
+// other users only their own, which is what they get without asking.
+func (app *application) listFor(r *http.Request, userID int, action string) (int, error) {
+	if user := app.contextGetUser(r); userID == 0 && user != nil {
+		if user.Staff {
+			return 0, nil
+		}
+		return user.ID, nil
 	}
+	return userID, app.actFor(r, userID, action)
+}
 
-	var payload jsonResponse
-	payload.Error = true
-	payload.Message = err.Error()
+// showFor checks the requester may see a record of entity, e.g. "loan",
+// belonging to the user with the given id. Records of other users are
+// reported missing, so nobody learns they exist.
+func (app *application) showFor(r *http.Request, userID int, entity string) error {
+	user := app.contextGetUser(r)
+	if user != nil && (user.ID == userID || user.Staff) {
+		return nil
+	}
+	return &data.Error{Kind: data.ErrNotFound, Code: entity + "_not_found", Message: entity + " not found"}
+}
 
-	err = app.writeJSON(w, statusCode, payload)
-	if err != nil {
-		app.errorLog.Println(err)
+// readIDParam returns the numeric {id} URL parameter of r.
+func (app *application) readIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return 0, badRequest("invalid_id", "id must be a positive integer", err)
 	}
+
+	return id, nil
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jumaniyozov/gobook/internal/data"
+	"github.com/jumaniyozov/gobook/internal/validator"
+)
+
+// Books are editions of works, see data.Work. A work is made along with its
+// first edition, and goes once its last edition is deleted for good, so only
+// its title can be changed here.
+
+// ShowWork returns a work with its editions.
+func (app *application) ShowWork(w http.ResponseWriter, r *http.Request) {
+	workID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	work, err := app.models.Work.GetByID(r.Context(), workID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"work": work},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// UpdateWork renames a work. Works with a single edition are renamed along
+// with it, so this is mostly for works with several.
+func (app *application) UpdateWork(w http.ResponseWriter, r *http.Request) {
+	workID, err := app.readIDParam(r)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title" validate:"required,max=512"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Struct(&input)
+	if !v.Valid() {
+		app.errorResponse(w, r, data.FieldErrors(v.Errors))
+		return
+	}
+
+	work := data.Work{ID: workID, Title: input.Title}
+	err = work.Update(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	saved, err := app.models.Work.GetByID(r.Context(), workID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+		Data:    envelope{"work": saved},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -3,9 +3,13 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jumaniyozov/gobook/internal/identifier"
 	"github.com/mozillazg/go-slugify"
 )
 
@@ -21,6 +25,42 @@ type Book struct {
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 	GenreIDs        []int     `json:"genre_ids,omitempty"`
+	Version         int       `json:"version"` // bumped by every update
+
+	// Contributors lists everyone who worked on the book, in order. Author is
+	// the first of them with the author role.
+	Contributors []Contributor `json:"contributors"`
+
+	// Series lists the series the book is in, when looking up a single book.
+	Series []BookSeries `json:"series,omitempty"`
+
+	// Availability counts the copies that can be lent out, when looking up a
+	// single book.
+	Availability *Availability `json:"availability,omitempty"`
+
+	// A book is one edition of a work, see Work. Work is only filled in when
+	// looking up a single book.
+	WorkID       int    `json:"work_id"`
+	Work         *Work  `json:"work,omitempty"`
+	EditionCount int    `json:"edition_count"` // of the work, not counting the trash
+	Format       string `json:"format,omitempty"`
+	PublisherID  int    `json:"publisher_id,omitempty"`
+	Publisher    string `json:"publisher,omitempty"` // the name of the publisher, when reading
+	ImprintID    int    `json:"imprint_id,omitempty"`
+	Imprint      string `json:"imprint,omitempty"` // the name of the imprint, when reading
+	PageCount    int    `json:"page_count,omitempty"`
+	Language     string `json:"language,omitempty"` // a BCP 47 tag, e.g. en or pt-BR
+
+	// standard identifiers, normalized; empty when unknown
+	ISBN13 string `json:"isbn13,omitempty"`
+	ISBN10 string `json:"isbn10,omitempty"` // derived from ISBN13, when it has one
+	OCLC   string `json:"oclc,omitempty"`
+	LCCN   string `json:"lccn,omitempty"`
+	DOI    string `json:"doi,omitempty"`
+
+	// set when saving, and recorded in the revision the save creates
+	CoverKey string `json:"-"` // identifies a newly uploaded cover, empty to keep the current one
+	EditorID int    `json:"-"` // the user making the change, if known
 }
 
 type Author struct {
@@ -28,6 +68,27 @@ type Author struct {
 	AuthorName string    `json:"author_name"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+	Works      int       `json:"works,omitempty"` // books contributed to, in any role, when listing authors
+}
+
+// Contributor is an author's part in a book.
+type Contributor struct {
+	AuthorID   int    `json:"author_id"`
+	AuthorName string `json:"author_name,omitempty"`
+	Role       string `json:"role"`
+	Position   int    `json:"position"` // 1 for the first contributor listed
+}
+
+// ContributorRoles are the parts an author can have in a book.
+var ContributorRoles = []string{"author", "editor", "translator", "illustrator"}
+
+// Contribution is a book an author contributed to, in one or more roles.
+type Contribution struct {
+	BookID          int      `json:"book_id"`
+	Title           string   `json:"title"`
+	Slug            string   `json:"slug"`
+	PublicationYear int      `json:"publication_year"`
+	Roles           []string `json:"roles"`
 }
 
 type Genre struct {
@@ -37,194 +98,323 @@ type Genre struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func (b *Book) GetAll() ([]*Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Count returns the number of books in the catalogue, not counting the trash.
+func (b *Book) Count(ctx context.Context) (int, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select b.id, b.title, b.author_id, b.publication_year, b.slug, b.description, b.created_at, b.updated_at,
+	var n int
+	err := db.QueryRowContext(ctx, `select count(*) from books where deleted_at is null`).Scan(&n)
+	return n, err
+}
+
+// CatalogVersion returns how many live books there are and when any book,
+// author, genre, work, publisher or imprint last changed, trashed books
+// included as trashing and restoring touch updated_at. Works count because
+// searches match their titles; genre and contributor links only change with
+// their book. The book listing can't change without one of the two changing,
+// so callers can tell a client its copy is current without loading it.
+func (b *Book) CatalogVersion(ctx context.Context) (int, time.Time, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select (select count(*) from books where deleted_at is null),
+			greatest((select max(updated_at) from books),
+				(select max(updated_at) from authors),
+				(select max(updated_at) from genres),
+				(select max(updated_at) from works),
+				(select max(updated_at) from publishers),
+				(select max(updated_at) from imprints))`
+
+	var count int
+	var modified sql.NullTime
+
+	err := db.QueryRowContext(ctx, query).Scan(&count, &modified)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, modified.Time, nil
+}
+
+// bookQuery selects the columns scanBook reads, for books joined with their
+// author, publisher and imprint as b, a, p and i.
+const bookQuery = `select b.id, b.title, b.author_id, b.publication_year, b.slug, b.description, b.created_at, b.updated_at, b.version,
+			coalesce(b.isbn13, ''), coalesce(b.oclc, ''), coalesce(b.lccn, ''), coalesce(b.doi, ''),
+			b.work_id, b.format, b.page_count, b.language,
+			coalesce(b.publisher_id, 0), coalesce(p.name, ''), coalesce(b.imprint_id, 0), coalesce(i.name, ''),
+			(select count(*) from books e where e.work_id = b.work_id and e.deleted_at is null),
 			a.id, a.author_name, a.created_at, a.updated_at
 			from books b
 			left join authors a on (b.author_id = a.id)
-			order by b.title`
+			left join publishers p on (p.id = b.publisher_id)
+			left join imprints i on (i.id = b.imprint_id)`
 
-	var books []*Book
+// scanBook reads a row selected by bookQuery.
+func scanBook(row interface{ Scan(...any) error }) (*Book, error) {
+	var book Book
 
-	rows, err := db.QueryContext(ctx, query)
+	err := row.Scan(
+		&book.ID,
+		&book.Title,
+		&book.AuthorID,
+		&book.PublicationYear,
+		&book.Slug,
+		&book.Description,
+		&book.CreatedAt,
+		&book.UpdatedAt,
+		&book.Version,
+		&book.ISBN13,
+		&book.OCLC,
+		&book.LCCN,
+		&book.DOI,
+		&book.WorkID,
+		&book.Format,
+		&book.PageCount,
+		&book.Language,
+		&book.PublisherID,
+		&book.Publisher,
+		&book.ImprintID,
+		&book.Imprint,
+		&book.EditionCount,
+		&book.Author.ID,
+		&book.Author.AuthorName,
+		&book.Author.CreatedAt,
+		&book.Author.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var book Book
-		err := rows.Scan(
-			&book.ID,
-			&book.Title,
-			&book.AuthorID,
-			&book.PublicationYear,
-			&book.Slug,
-			&book.Description,
-			&book.CreatedAt,
-			&book.UpdatedAt,
-			&book.Author.ID,
-			&book.Author.AuthorName,
-			&book.Author.CreatedAt,
-			&book.Author.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
 
-		genres, ids, err := b.genresForBook(book.ID)
-		if err != nil {
-			return nil, err
-		}
-		book.Genres = genres
-		book.GenreIDs = ids
+	book.ISBN10, _ = identifier.ISBN10(book.ISBN13)
+	return &book, nil
+}
 
-		books = append(books, &book)
+// loadRelations fills in the genres and contributors of book.
+func (b *Book) loadRelations(ctx context.Context, book *Book) error {
+	genres, ids, err := b.genresForBook(ctx, book.ID)
+	if err != nil {
+		return err
 	}
+	book.Genres = genres
+	book.GenreIDs = ids
 
-	return books, nil
+	book.Contributors, err = b.contributorsForBook(ctx, book.ID)
+	return err
 }
 
-func (b *Book) GetAllPaginated(page, pageSize int) ([]*Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// list returns the books selected by bookQuery followed by clauses.
+func (b *Book) list(ctx context.Context, clauses string, args ...any) ([]*Book, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	limit := pageSize
-	offset := (page - 1) * pageSize
-
-	query := `select b.id, b.title, b.author_id, b.publication_year, b.slug, b.description, b.created_at, b.updated_at,
-			a.id, a.author_name, a.created_at, a.updated_at
-			from books b
-			left join authors a on (b.author_id = a.id)
-			order by b.title
-			limit $1 offset $2`
-
-	var books []*Book
-
-	rows, err := db.QueryContext(ctx, query, limit, offset)
+	rows, err := db.QueryContext(ctx, bookQuery+" "+clauses, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var books []*Book
 	for rows.Next() {
-		var book Book
-		err := rows.Scan(
-			&book.ID,
-			&book.Title,
-			&book.AuthorID,
-			&book.PublicationYear,
-			&book.Slug,
-			&book.Description,
-			&book.CreatedAt,
-			&book.UpdatedAt,
-			&book.Author.ID,
-			&book.Author.AuthorName,
-			&book.Author.CreatedAt,
-			&book.Author.UpdatedAt)
+		book, err := scanBook(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		genres, ids, err := b.genresForBook(book.ID)
+		err = b.loadRelations(ctx, book)
 		if err != nil {
 			return nil, err
 		}
-		book.Genres = genres
-		book.GenreIDs = ids
 
-		books = append(books, &book)
+		books = append(books, book)
 	}
 
-	return books, nil
+	return books, rows.Err()
 }
 
-func (b *Book) GetOneById(id int) (*Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// one returns the book selected by bookQuery where condition holds.
+func (b *Book) one(ctx context.Context, condition string, args ...any) (*Book, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select b.id, b.title, b.author_id, b.publication_year, b.slug, b.description, b.created_at, b.updated_at,
-			a.id, a.author_name, a.created_at, a.updated_at
-			from books b
-			left join authors a on (b.author_id = a.id)
-			where b.id = $1`
+	row := db.QueryRowContext(ctx, bookQuery+" where "+condition+" and b.deleted_at is null", args...)
 
-	row := db.QueryRowContext(ctx, query, id)
+	book, err := scanBook(row)
+	if err != nil {
+		return nil, wrapError(err, "book")
+	}
 
-	var book Book
+	err = b.loadRelations(ctx, book)
+	if err != nil {
+		return nil, err
+	}
 
-	err := row.Scan(
-		&book.ID,
-		&book.Title,
-		&book.AuthorID,
-		&book.PublicationYear,
-		&book.Slug,
-		&book.Description,
-		&book.CreatedAt,
-		&book.UpdatedAt,
-		&book.Author.ID,
-		&book.Author.AuthorName,
-		&book.Author.CreatedAt,
-		&book.Author.UpdatedAt)
+	book.Series, err = seriesForBook(ctx, book.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	genres, ids, err := b.genresForBook(book.ID)
+	book.Work, err = workByID(ctx, book.WorkID)
 	if err != nil {
 		return nil, err
 	}
-	book.Genres = genres
-	book.GenreIDs = ids
 
-	return &book, nil
+	book.Availability, err = availabilityOf(ctx, book.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return book, nil
 }
 
-func (b *Book) GetOneBySlug(slug string) (*Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+func (b *Book) GetAll(ctx context.Context) ([]*Book, error) {
+	return b.list(ctx, `where b.deleted_at is null order by b.title`)
+}
 
-	query := `select b.id, b.title, b.author_id, b.publication_year, b.slug, b.description, b.created_at, b.updated_at,
-			a.id, a.author_name, a.created_at, a.updated_at
-			from books b
-			left join authors a on (b.author_id = a.id)
-			where b.slug = $1`
+// BookFilter narrows down the books listed by Search. Zero values don't
+// filter.
+type BookFilter struct {
+	Query       string // contained in the title, the work's title or the author's name
+	PublisherID int    // published by, under any of its imprints
+}
 
-	row := db.QueryRowContext(ctx, query, slug)
+// Search returns the books that pass filter, by title. When searching by
+// Query, editions are collapsed by work: each work is represented by its
+// oldest matching edition.
+func (b *Book) Search(ctx context.Context, filter BookFilter) ([]*Book, error) {
+	conditions := []string{"e.deleted_at is null"}
+	var args []any
+
+	if filter.Query != "" {
+		args = append(args, filter.Query)
+		conditions = append(conditions, fmt.Sprintf(`(strpos(lower(e.title), lower($%[1]d)) > 0
+					or strpos(lower(w.title), lower($%[1]d)) > 0
+					or strpos(lower(ea.author_name), lower($%[1]d)) > 0)`, len(args)))
+	}
+	if filter.PublisherID != 0 {
+		args = append(args, filter.PublisherID)
+		conditions = append(conditions, fmt.Sprintf(`e.publisher_id = $%d`, len(args)))
+	}
 
-	var book Book
+	selection, order := `select e.id`, ``
+	if filter.Query != "" {
+		selection, order = `select distinct on (e.work_id) e.id`, ` order by e.work_id, e.publication_year, e.id`
+	}
 
-	err := row.Scan(
-		&book.ID,
-		&book.Title,
-		&book.AuthorID,
-		&book.PublicationYear,
-		&book.Slug,
-		&book.Description,
-		&book.CreatedAt,
-		&book.UpdatedAt,
-		&book.Author.ID,
-		&book.Author.AuthorName,
-		&book.Author.CreatedAt,
-		&book.Author.UpdatedAt)
-	if err != nil {
-		return nil, err
+	matching := selection + `
+				from books e
+				join works w on (w.id = e.work_id)
+				left join authors ea on (ea.id = e.author_id)
+				where ` + strings.Join(conditions, " and ") + order
+
+	return b.list(ctx, `where b.id in (`+matching+`) order by b.title`, args...)
+}
+
+func (b *Book) GetAllPaginated(ctx context.Context, page, pageSize int) ([]*Book, error) {
+	limit := pageSize
+	offset := (page - 1) * pageSize
+
+	return b.list(ctx, `where b.deleted_at is null order by b.title limit $1 offset $2`, limit, offset)
+}
+
+func (b *Book) GetOneById(ctx context.Context, id int) (*Book, error) {
+	return b.one(ctx, `b.id = $1`, id)
+}
+
+func (b *Book) GetOneBySlug(ctx context.Context, slug string) (*Book, error) {
+	return b.one(ctx, `b.slug = $1`, slug)
+}
+
+// Identifiers that books can be looked up by, see GetOneByIdentifier.
+const (
+	ISBN = "isbn"
+	OCLC = "oclc"
+	LCCN = "lccn"
+	DOI  = "doi"
+)
+
+// identifierConditions finds a book by each kind of identifier.
+var identifierConditions = map[string]string{
+	ISBN: `b.isbn13 = $1`,
+	OCLC: `b.oclc = $1`,
+	LCCN: `b.lccn = $1`,
+	DOI:  `lower(b.doi) = lower($1)`,
+}
+
+// GetOneByIdentifier returns the book with the given identifier of kind, one
+// of ISBN, OCLC, LCCN or DOI. value must be normalized as the identifier
+// package does, and an ISBN given as an ISBN-13.
+func (b *Book) GetOneByIdentifier(ctx context.Context, kind, value string) (*Book, error) {
+	condition, ok := identifierConditions[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown identifier %q", kind)
 	}
 
-	genres, ids, err := b.genresForBook(book.ID)
+	return b.one(ctx, condition, value)
+}
+
+// CurrentSlug returns the slug currently used by the book that was previously
+// known by slug.
+func (b *Book) CurrentSlug(ctx context.Context, slug string) (string, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select b.slug from book_slug_history h
+			join books b on (h.book_id = b.id)
+			where h.slug = $1 and b.deleted_at is null`
+
+	var current string
+	err := db.QueryRowContext(ctx, query, slug).Scan(&current)
 	if err != nil {
-		return nil, err
+		return "", wrapError(err, "book")
 	}
-	book.Genres = genres
-	book.GenreIDs = ids
 
-	return &book, nil
+	return current, nil
 }
 
-func (b *Book) genresForBook(id int) ([]Genre, []int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// uniqueSlug builds a slug for title that isn't used, now or in the past, by any
+// book other than bookID. The plain title is preferred, then the title qualified
+// with the author's name, then numbered variants of that.
+func (b *Book) uniqueSlug(ctx context.Context, q querier, title string, authorID, bookID int) (string, error) {
+	base := slugify.Slugify(title)
+	if base == "" {
+		base = "book"
+	}
+
+	candidates := []string{base}
+
+	var authorName string
+	err := q.QueryRowContext(ctx, `select author_name from authors where id = $1`, authorID).Scan(&authorName)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if qualified := slugify.Slugify(authorName); qualified != "" {
+		base = fmt.Sprintf("%s-%s", base, qualified)
+		candidates = append(candidates, base)
+	}
+
+	query := `select id from books where slug = $1
+			union all
+			select book_id from book_slug_history where slug = $1
+			limit 1`
+
+	for n := 2; ; n++ {
+		for _, slug := range candidates {
+			var owner int
+			err := q.QueryRowContext(ctx, query, slug).Scan(&owner)
+			if err == sql.ErrNoRows || (err == nil && owner == bookID && bookID != 0) {
+				return slug, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		candidates = []string{fmt.Sprintf("%s-%d", base, n)}
+	}
+}
+
+func (b *Book) genresForBook(ctx context.Context, id int) ([]Genre, []int, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
 	var genres []Genre
@@ -256,109 +446,303 @@ func (b *Book) genresForBook(id int) ([]Genre, []int, error) {
 	return genres, genreIDs, nil
 }
 
-func (b *Book) Insert(book Book) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// contributorsForBook returns the contributors of the book with the given id,
+// in order.
+func (b *Book) contributorsForBook(ctx context.Context, id int) ([]Contributor, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	stmt := `insert into books (title, author_id, publication_year, slug, description, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	query := `select c.author_id, a.author_name, c.role, c.position
+			from book_contributors c
+			join authors a on (a.id = c.author_id)
+			where c.book_id = $1
+			order by c.position`
+
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributors := []Contributor{}
+	for rows.Next() {
+		var c Contributor
+		err := rows.Scan(&c.AuthorID, &c.AuthorName, &c.Role, &c.Position)
+		if err != nil {
+			return nil, err
+		}
+		contributors = append(contributors, c)
+	}
+
+	return contributors, rows.Err()
+}
+
+// setContributors replaces the contributors of the book with the given id,
+// numbering them in the order given.
+func setContributors(ctx context.Context, q querier, bookID int, contributors []Contributor) error {
+	_, err := q.ExecContext(ctx, `delete from book_contributors where book_id = $1`, bookID)
+	if err != nil {
+		return wrapError(err, "book")
+	}
+
+	stmt := `insert into book_contributors (book_id, author_id, role, position) values ($1, $2, $3, $4)`
+	for i, c := range contributors {
+		_, err = q.ExecContext(ctx, stmt, bookID, c.AuthorID, c.Role, i+1)
+		if err != nil {
+			return wrapError(err, "book")
+		}
+	}
+
+	return nil
+}
+
+// setGenres replaces the genres of the book with the given id.
+func setGenres(ctx context.Context, q querier, bookID int, genreIDs []int) error {
+	_, err := q.ExecContext(ctx, `delete from books_genres where book_id = $1`, bookID)
+	if err != nil {
+		return wrapError(err, "book")
+	}
+
+	stmt := `insert into books_genres (book_id, genre_id, created_at, updated_at) values ($1, $2, now(), now())`
+	for _, id := range genreIDs {
+		_, err = q.ExecContext(ctx, stmt, bookID, id)
+		if err != nil {
+			return wrapError(err, "book")
+		}
+	}
+
+	return nil
+}
+
+// Insert saves book as a new book, with its genres, contributors and first
+// revision, and returns its id. Nothing is saved if any part fails.
+func (b *Book) Insert(ctx context.Context, book Book) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// a book that isn't an edition of a known work is the first of a new one,
+	// which goes again if the book can't be saved
+	if book.WorkID == 0 {
+		book.WorkID, err = insertWork(ctx, tx, book.Title)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	slug, err := b.uniqueSlug(ctx, tx, book.Title, book.AuthorID, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into books (title, author_id, publication_year, slug, description, cover_key, created_at, updated_at,
+				isbn13, oclc, lccn, doi, work_id, format, publisher_id, page_count, language, imprint_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, ''), nullif($10, ''), nullif($11, ''), nullif($12, ''),
+				$13, $14, nullif($15, 0), $16, $17, nullif($18, 0))
+			returning id`
 
 	var newID int
-	err := db.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		book.Title,
 		book.AuthorID,
 		book.PublicationYear,
-		slugify.Slugify(book.Title),
+		slug,
 		book.Description,
+		book.CoverKey,
 		time.Now(),
 		time.Now(),
+		book.ISBN13,
+		book.OCLC,
+		book.LCCN,
+		book.DOI,
+		book.WorkID,
+		book.Format,
+		book.PublisherID,
+		book.PageCount,
+		book.Language,
+		book.ImprintID,
 	).Scan(&newID)
+	if err != nil {
+		return 0, wrapError(err, "book")
+	}
+
+	err = setGenres(ctx, tx, newID, book.GenreIDs)
 	if err != nil {
 		return 0, err
 	}
 
-	if len(book.GenreIDs) > 0 {
-		stmt = `delete from books_genres where book_id = $1`
-		_, err := db.ExecContext(ctx, stmt, book.ID)
-		if err != nil {
-			return newID, fmt.Errorf("book updated, but genres not: %s", err.Error())
-		}
+	err = setContributors(ctx, tx, newID, book.Contributors)
+	if err != nil {
+		return 0, err
+	}
 
-		for _, x := range book.GenreIDs {
-			stmt = `insert into books_genres (book_id, genre_id, created_at, updated_at)
-				values ($1, $2, $3, $4)`
-			_, err = db.ExecContext(ctx, stmt, newID, x, time.Now(), time.Now())
-			if err != nil {
-				return newID, fmt.Errorf("book updated, but genres not: %s", err.Error())
-			}
-		}
+	err = recordRevision(ctx, tx, newID, book.EditorID)
+	if err != nil {
+		return 0, err
 	}
 
-	return newID, nil
+	return newID, tx.Commit()
 }
 
-func (b *Book) Update() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Update saves b, replacing its genres and contributors, and records the new
+// revision, all at once or not at all. b.Version must match the stored
+// version, so an edit of an outdated copy fails with an edit_conflict error
+// rather than overwriting someone else's changes. b.Version is the new version
+// afterwards. A zero b.WorkID keeps the book an edition of the work
+// it's in.
+func (b *Book) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSlug string
+	var version, oldWorkID int
+	query := `select slug, version, work_id from books where id = $1 and deleted_at is null for update`
+	err = tx.QueryRowContext(ctx, query, b.ID).Scan(&oldSlug, &version, &oldWorkID)
+	if err != nil {
+		return wrapError(err, "book")
+	}
+	if b.Version != version {
+		return editConflict("book")
+	}
+
+	slug, err := b.uniqueSlug(ctx, tx, b.Title, b.AuthorID, b.ID)
+	if err != nil {
+		return err
+	}
+
 	stmt := `update books set
 		title = $1,
 		author_id = $2,
 		publication_year = $3,
         slug = $4,
     	description = $5,
-		updated_at = $6
-		where id = $7`
-
-	_, err := db.ExecContext(ctx, stmt,
+		updated_at = $6,
+		cover_key = coalesce(nullif($9, ''), cover_key),
+		isbn13 = nullif($10, ''),
+		oclc = nullif($11, ''),
+		lccn = nullif($12, ''),
+		doi = nullif($13, ''),
+		work_id = coalesce(nullif($14, 0), work_id),
+		format = $15,
+		publisher_id = nullif($16, 0),
+		page_count = $17,
+		language = $18,
+		imprint_id = nullif($19, 0),
+		version = version + 1
+		where id = $7 and version = $8 and deleted_at is null
+		returning version`
+
+	err = tx.QueryRowContext(ctx, stmt,
 		b.Title,
 		b.AuthorID,
 		b.PublicationYear,
-		slugify.Slugify(b.Title),
+		slug,
 		b.Description,
 		time.Now(),
-		b.ID)
+		b.ID,
+		version,
+		b.CoverKey,
+		b.ISBN13,
+		b.OCLC,
+		b.LCCN,
+		b.DOI,
+		b.WorkID,
+		b.Format,
+		b.PublisherID,
+		b.PageCount,
+		b.Language,
+		b.ImprintID).Scan(&b.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// changed between reading the version and writing
+		return editConflict("book")
+	}
 	if err != nil {
-		return err
+		return wrapError(err, "book")
 	}
 
-	if len(b.GenreIDs) > 0 {
-		stmt = `delete from books_genres where book_id = $1`
-		_, err := db.ExecContext(ctx, stmt, b.ID)
+	if slug != oldSlug {
+		// keep the old slug pointing at this book so shared links can be
+		// redirected, and drop the new one from history if we're renaming back
+		stmt = `delete from book_slug_history where slug = $1`
+		_, err = tx.ExecContext(ctx, stmt, slug)
 		if err != nil {
-			return fmt.Errorf("book updated, but genres not: %s", err.Error())
+			return err
 		}
 
-		for _, x := range b.GenreIDs {
-			stmt = `insert into books_genres (book_id, genre_id, created_at, updated_at)
-				values ($1, $2, $3, $4)`
-			_, err = db.ExecContext(ctx, stmt, b.ID, x, time.Now(), time.Now())
-			if err != nil {
-				return fmt.Errorf("book updated, but genres not: %s", err.Error())
-			}
+		stmt = `insert into book_slug_history (book_id, slug, created_at) values ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, stmt, b.ID, oldSlug, time.Now())
+		if err != nil {
+			return err
 		}
 	}
+	b.Slug = slug
 
-	return nil
+	if b.WorkID != 0 && b.WorkID != oldWorkID {
+		err = deleteEmptyWork(ctx, tx, oldWorkID)
+		if err != nil {
+			return err
+		}
+	} else {
+		b.WorkID = oldWorkID
+	}
+
+	err = syncWorkTitle(ctx, tx, b.WorkID, b.Title)
+	if err != nil {
+		return err
+	}
+
+	err = setGenres(ctx, tx, b.ID, b.GenreIDs)
+	if err != nil {
+		return err
+	}
+
+	err = setContributors(ctx, tx, b.ID, b.Contributors)
+	if err != nil {
+		return err
+	}
+
+	err = recordRevision(ctx, tx, b.ID, b.EditorID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (b *Book) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeleteByID moves the book to the trash, from where it can be restored until
+// the trash is purged.
+func (b *Book) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
-	stmt := `delete from books where id = $1`
-	_, err := db.ExecContext(ctx, stmt, id)
+	stmt := `update books set deleted_at = now(), updated_at = now() where id = $1 and deleted_at is null`
+	res, err := db.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
-	return nil
+	return requireRows(res, "book")
 }
 
-func (a *Author) All() ([]*Author, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Author) All(ctx context.Context) ([]*Author, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select id, author_name, created_at, updated_at  from authors order by author_name`
+	query := `select a.id, a.author_name, a.created_at, a.updated_at,
+			(select count(distinct c.book_id) from book_contributors c
+				join books b on (b.id = c.book_id)
+				where c.author_id = a.id and b.deleted_at is null)
+			from authors a where a.deleted_at is null order by a.author_name`
 	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -368,7 +752,7 @@ func (a *Author) All() ([]*Author, error) {
 
 	for rows.Next() {
 		var author Author
-		err := rows.Scan(&author.ID, &author.AuthorName, &author.CreatedAt, &author.UpdatedAt)
+		err := rows.Scan(&author.ID, &author.AuthorName, &author.CreatedAt, &author.UpdatedAt, &author.Works)
 		if err != nil {
 			return nil, err
 		}
@@ -376,3 +760,164 @@ func (a *Author) All() ([]*Author, error) {
 	}
 	return authors, nil
 }
+
+// GetOne returns the author with the given id, with the number of books they
+// contributed to.
+func (a *Author) GetOne(ctx context.Context, id int) (*Author, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select a.id, a.author_name, a.created_at, a.updated_at,
+			(select count(distinct c.book_id) from book_contributors c
+				join books b on (b.id = c.book_id)
+				where c.author_id = a.id and b.deleted_at is null)
+			from authors a where a.id = $1 and a.deleted_at is null`
+
+	var author Author
+	err := db.QueryRowContext(ctx, query, id).Scan(&author.ID, &author.AuthorName, &author.CreatedAt, &author.UpdatedAt, &author.Works)
+	if err != nil {
+		return nil, wrapError(err, "author")
+	}
+
+	return &author, nil
+}
+
+// Contributions returns the books the author with the given id contributed
+// to, newest first, with every role they had in each.
+func (a *Author) Contributions(ctx context.Context, id int) ([]*Contribution, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select b.id, b.title, b.slug, b.publication_year,
+			array_to_json(array_agg(c.role order by c.position))
+			from book_contributors c
+			join books b on (b.id = c.book_id)
+			where c.author_id = $1 and b.deleted_at is null
+			group by b.id
+			order by b.publication_year desc, b.title`
+
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	works := []*Contribution{}
+	for rows.Next() {
+		var work Contribution
+		var roles []byte
+		err := rows.Scan(&work.BookID, &work.Title, &work.Slug, &work.PublicationYear, &roles)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(roles, &work.Roles)
+		if err != nil {
+			return nil, err
+		}
+		works = append(works, &work)
+	}
+
+	return works, rows.Err()
+}
+
+// Missing returns those of ids that don't belong to any author, or belong to
+// one in the trash.
+func (a *Author) Missing(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select id from unnest($1::integer[]) as id
+			where id not in (select id from authors where deleted_at is null)
+			order by id`
+
+	rows, err := db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		missing = append(missing, id)
+	}
+
+	return missing, rows.Err()
+}
+
+// Exists reports whether an author with the given id exists and isn't in the
+// trash.
+func (a *Author) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var exists bool
+	query := `select exists(select 1 from authors where id = $1 and deleted_at is null)`
+	err := db.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// DeleteByID moves the author to the trash. Contributors to books that aren't
+// in the trash themselves can't be deleted.
+func (a *Author) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update authors set deleted_at = now(), updated_at = now()
+			where id = $1 and deleted_at is null
+			and not exists(select 1 from book_contributors c
+				join books b on (b.id = c.book_id)
+				where c.author_id = $1 and b.deleted_at is null)`
+	res, err := db.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	exists, err := a.Exists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &Error{Kind: ErrConflict, Code: "author_has_books", Message: "the author still contributes to books, delete them first"}
+	}
+	return notFound("author", nil)
+}
+
+// Missing returns those of ids that don't belong to any genre.
+func (g *Genre) Missing(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select id from unnest($1::integer[]) as id
+			where id not in (select id from genres)
+			order by id`
+
+	rows, err := db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		missing = append(missing, id)
+	}
+
+	return missing, rows.Err()
+}
@@ -0,0 +1,439 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Copy is a physical copy of a book that can be lent out.
+type Copy struct {
+	ID        int       `json:"id"`
+	BookID    int       `json:"book_id"`
+	Barcode   string    `json:"barcode"`
+	Location  string    `json:"location"`
+	Condition string    `json:"condition"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Statuses of a copy. A copy is on loan from checkout until it's returned, and
+// on hold while put aside for a ready Hold; the others are set by hand.
+const (
+	CopyAvailable = "available"
+	CopyOnLoan    = "on_loan"
+	CopyOnHold    = "on_hold"
+	CopyLost      = "lost"
+	CopyWithdrawn = "withdrawn"
+)
+
+// CopyConditions describe the wear of a copy, best first.
+var CopyConditions = []string{"new", "good", "fair", "poor", "damaged"}
+
+// Loan is a copy lent to a user.
+type Loan struct {
+	ID           int        `json:"id"`
+	CopyID       int        `json:"copy_id"`
+	Barcode      string     `json:"barcode"`
+	BookID       int        `json:"book_id"`
+	Title        string     `json:"title"`
+	UserID       int        `json:"user_id"`
+	Email        string     `json:"-"` // of the user, for notifications
+	FirstName    string     `json:"-"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at"`
+	ReturnedAt   *time.Time `json:"returned_at"` // nil while the copy is out
+	Renewals     int        `json:"renewals"`
+	Overdue      bool       `json:"overdue"`
+	OverdueAt    *time.Time `json:"overdue_at,omitempty"` // when the overdue job first flagged it
+	Fine         int        `json:"fine"`                 // accrued so far, in cents
+}
+
+// LoanPolicy limits what users can borrow.
+type LoanPolicy struct {
+	Period      time.Duration // from checkout, or renewal, to the due date
+	MaxRenewals int
+	MaxPerUser  int // open loans at once
+	MaxBalance  int // fines owed, in cents, above which checkouts are refused
+}
+
+// Availability counts the copies of a book that can be lent out.
+type Availability struct {
+	Copies    int        `json:"copies"`                // not counting lost or withdrawn copies
+	Available int        `json:"available"`             // on the shelf, not put aside for a hold
+	Holds     int        `json:"holds"`                 // waiting for a copy
+	NextDueAt *time.Time `json:"next_due_at,omitempty"` // of the copies on loan, when none are available
+	UpdatedAt time.Time  `json:"-"`                     // of the last change to a copy or loan
+}
+
+// LoanFilter narrows down the loans listed by Loan.All. Zero values don't
+// filter.
+type LoanFilter struct {
+	UserID int
+	Status string // one of LoanStatuses
+}
+
+// LoanStatuses are the states loans can be listed by.
+var LoanStatuses = []string{"open", "overdue", "returned"}
+
+// ForBook returns the copies of the book with the given id, by barcode.
+func (c *Copy) ForBook(ctx context.Context, bookID int) ([]*Copy, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, copyQuery+` where book_id = $1 order by barcode`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []*Copy{}
+	for rows.Next() {
+		copy, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, copy)
+	}
+
+	return copies, rows.Err()
+}
+
+// GetOne returns the copy with the given id.
+func (c *Copy) GetOne(ctx context.Context, id int) (*Copy, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	copy, err := scanCopy(db.QueryRowContext(ctx, copyQuery+` where id = $1`, id))
+	if err != nil {
+		return nil, wrapError(err, "copy")
+	}
+
+	return copy, nil
+}
+
+// GetByBarcode returns the copy with the given barcode.
+func (c *Copy) GetByBarcode(ctx context.Context, barcode string) (*Copy, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	copy, err := scanCopy(db.QueryRowContext(ctx, copyQuery+` where barcode = $1`, barcode))
+	if err != nil {
+		return nil, wrapError(err, "copy")
+	}
+
+	return copy, nil
+}
+
+// Insert adds an available copy and returns its id.
+func (c *Copy) Insert(ctx context.Context, copy Copy) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `insert into copies (book_id, barcode, location, condition, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5) returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, copy.BookID, copy.Barcode, copy.Location, copy.Condition, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, wrapError(err, "copy")
+	}
+
+	return id, nil
+}
+
+// Update saves the barcode, location, condition and status of c. Copies on
+// loan or on hold keep their status until they're returned or picked up.
+func (c *Copy) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update copies set barcode = $1, location = $2, condition = $3, status = $4, updated_at = $5
+			where id = $6 and (status = $4 or (status not in ('on_loan', 'on_hold') and $4 not in ('on_loan', 'on_hold')))`
+
+	res, err := db.ExecContext(ctx, stmt, c.Barcode, c.Location, c.Condition, c.Status, time.Now(), c.ID)
+	if err != nil {
+		return wrapError(err, "copy")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	current, err := c.GetOne(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	if current.Status == CopyOnHold || c.Status == CopyOnHold {
+		return &Error{Kind: ErrConflict, Code: "copy_on_hold", Message: "copies go on and off hold by holds becoming ready and being picked up, cancelled or expired"}
+	}
+	return &Error{Kind: ErrConflict, Code: "copy_on_loan", Message: "copies go on and off loan by being checked out and returned"}
+}
+
+// availabilityOf returns how many copies of the book with the given id there
+// are and how many can be checked out now.
+func availabilityOf(ctx context.Context, bookID int) (*Availability, error) {
+	query := `select count(*) filter (where c.status in ('available', 'on_loan', 'on_hold')),
+				count(*) filter (where c.status = 'available'),
+				(select count(*) from holds where book_id = $1 and status = 'waiting'),
+				min(l.due_at),
+				coalesce(greatest(max(c.updated_at), max(l.updated_at),
+					(select max(updated_at) from holds where book_id = $1)), 'epoch')
+			from copies c
+			left join loans l on (l.copy_id = c.id and l.returned_at is null)
+			where c.book_id = $1`
+
+	var a Availability
+	var nextDue sql.NullTime
+	err := db.QueryRowContext(ctx, query, bookID).Scan(&a.Copies, &a.Available, &a.Holds, &nextDue, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if a.Available == 0 && nextDue.Valid {
+		a.NextDueAt = &nextDue.Time
+	}
+
+	return &a, nil
+}
+
+const copyQuery = `select id, book_id, barcode, location, condition, status, created_at, updated_at from copies`
+
+func scanCopy(row interface{ Scan(...any) error }) (*Copy, error) {
+	var c Copy
+	err := row.Scan(&c.ID, &c.BookID, &c.Barcode, &c.Location, &c.Condition, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// loanQuery selects the columns scanLoan reads, for loans joined with their
+// copy, its book and the borrower as l, c, b and u.
+const loanQuery = `select l.id, l.copy_id, c.barcode, c.book_id, b.title, l.user_id, u.email, u.first_name,
+			l.checked_out_at, l.due_at, l.returned_at, l.renewals,
+			l.returned_at is null and l.due_at < now(), l.overdue_at,
+			coalesce((select f.amount from ledger_entries f where f.loan_id = l.id and f.kind = 'fine'), 0)
+			from loans l
+			join copies c on (c.id = l.copy_id)
+			join books b on (b.id = c.book_id)
+			join users u on (u.id = l.user_id)`
+
+func scanLoan(row interface{ Scan(...any) error }) (*Loan, error) {
+	var loan Loan
+	var returned, overdue sql.NullTime
+
+	err := row.Scan(&loan.ID, &loan.CopyID, &loan.Barcode, &loan.BookID, &loan.Title, &loan.UserID, &loan.Email, &loan.FirstName,
+		&loan.CheckedOutAt, &loan.DueAt, &returned, &loan.Renewals, &loan.Overdue, &overdue, &loan.Fine)
+	if err != nil {
+		return nil, err
+	}
+	if returned.Valid {
+		loan.ReturnedAt = &returned.Time
+	}
+	if overdue.Valid {
+		loan.OverdueAt = &overdue.Time
+	}
+
+	return &loan, nil
+}
+
+// All returns the loans that pass filter, most recent first.
+func (l *Loan) All(ctx context.Context, filter LoanFilter) ([]*Loan, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := loanQuery + ` where ($1 = 0 or l.user_id = $1)`
+	switch filter.Status {
+	case "open":
+		query += ` and l.returned_at is null`
+	case "overdue":
+		query += ` and l.returned_at is null and l.due_at < now()`
+	case "returned":
+		query += ` and l.returned_at is not null`
+	}
+	query += ` order by l.checked_out_at desc, l.id desc`
+
+	rows, err := db.QueryContext(ctx, query, filter.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []*Loan{}
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+
+	return loans, rows.Err()
+}
+
+// GetOne returns the loan with the given id.
+func (l *Loan) GetOne(ctx context.Context, id int) (*Loan, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	loan, err := scanLoan(db.QueryRowContext(ctx, loanQuery+` where l.id = $1`, id))
+	if err != nil {
+		return nil, wrapError(err, "loan")
+	}
+
+	return loan, nil
+}
+
+// Checkout lends the copy with the given id to the user with the given id,
+// due after policy.Period, and returns the id of the loan. The copy must be
+// available, or on hold for the user, and the user active, below
+// policy.MaxPerUser open loans and owing no more than policy.MaxBalance. The
+// user's hold on the book, if any, is fulfilled.
+func (l *Loan) Checkout(ctx context.Context, copyID, userID int, policy LoanPolicy) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	var active int
+	err := db.QueryRowContext(ctx, `select user_active from users where id = $1 and deleted_at is null`, userID).Scan(&active)
+	if err != nil {
+		return 0, wrapError(err, "user")
+	}
+	if active != 1 {
+		return 0, &Error{Kind: ErrConflict, Code: "user_inactive", Message: "inactive users can't borrow copies"}
+	}
+
+	balance, err := balanceOf(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if balance > policy.MaxBalance {
+		return 0, &Error{Kind: ErrConflict, Code: "fines_owed", Message: "the user owes more in fines than allowed to borrow, pay or waive them first"}
+	}
+
+	// taking the copy off the shelf and lending it happen together, so two
+	// checkouts of the same copy can't both succeed
+	stmt := `with taken as (
+				update copies set status = 'on_loan', updated_at = now()
+				where id = $1
+				and (status = 'available' or (status = 'on_hold'
+					and exists(select 1 from holds where copy_id = $1 and status = 'ready' and user_id = $2)))
+				and (select count(*) from loans where user_id = $2 and returned_at is null) < $4
+				returning id, book_id),
+			fulfilled as (
+				update holds set status = 'fulfilled', updated_at = now()
+				from taken
+				where holds.user_id = $2 and holds.book_id = taken.book_id
+				and (holds.status = 'waiting' or (holds.status = 'ready' and holds.copy_id = $1)))
+			insert into loans (copy_id, user_id, due_at)
+			select id, $2, $3 from taken
+			returning id`
+
+	var id int
+	err = db.QueryRowContext(ctx, stmt, copyID, userID, time.Now().Add(policy.Period), policy.MaxPerUser).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, wrapError(err, "loan")
+	}
+
+	copy, err := (&Copy{}).GetOne(ctx, copyID)
+	if err != nil {
+		return 0, err
+	}
+	if copy.Status == CopyOnHold {
+		var mine bool
+		err := db.QueryRowContext(ctx, `select exists(select 1 from holds where copy_id = $1 and status = 'ready' and user_id = $2)`, copyID, userID).Scan(&mine)
+		if err != nil {
+			return 0, err
+		}
+		if !mine {
+			return 0, &Error{Kind: ErrConflict, Code: "copy_on_hold", Message: "the copy is put aside for another user's hold"}
+		}
+	} else if copy.Status != CopyAvailable {
+		return 0, &Error{Kind: ErrConflict, Code: "copy_unavailable", Message: "the copy is " + copy.Status + " and can't be checked out"}
+	}
+	return 0, &Error{Kind: ErrConflict, Code: "loan_limit_reached", Message: "the user already has as many copies out as they're allowed"}
+}
+
+// Return ends the loan with the given id and puts its copy back on the shelf.
+func (l *Loan) Return(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `with returned as (
+				update loans set returned_at = now(), updated_at = now()
+				where id = $1 and returned_at is null
+				returning copy_id)
+			update copies set status = 'available', updated_at = now()
+			from returned where copies.id = returned.copy_id`
+
+	res, err := db.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	return l.closedLoanError(ctx, id)
+}
+
+// Renew pushes the due date of the loan with the given id to policy.Period
+// from now, unless it's already later, and counts the renewal against
+// policy.MaxRenewals. Loans of books others are waiting for can't be renewed.
+func (l *Loan) Renew(ctx context.Context, id int, policy LoanPolicy) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	// a renewed loan is no longer overdue, and is flagged again if it goes
+	// past its new due date
+	stmt := `update loans set due_at = greatest(due_at, $2), renewals = renewals + 1, overdue_at = null, updated_at = now()
+			where id = $1 and returned_at is null and renewals < $3
+			and not exists(select 1 from holds h join copies c on (c.book_id = h.book_id)
+				where c.id = loans.copy_id and h.status = 'waiting')`
+
+	res, err := db.ExecContext(ctx, stmt, id, time.Now().Add(policy.Period), policy.MaxRenewals)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	if err := l.closedLoanError(ctx, id); err != nil {
+		return err
+	}
+
+	var held bool
+	query := `select exists(select 1 from loans l join copies c on (c.id = l.copy_id)
+				join holds h on (h.book_id = c.book_id)
+				where l.id = $1 and h.status = 'waiting')`
+	err = db.QueryRowContext(ctx, query, id).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return &Error{Kind: ErrConflict, Code: "book_has_holds", Message: "others are waiting for the book, so the loan can't be renewed"}
+	}
+	return &Error{Kind: ErrConflict, Code: "renewal_limit_reached", Message: "the loan has been renewed as many times as allowed"}
+}
+
+// closedLoanError explains why the loan with the given id couldn't be changed
+// when it's missing or returned, and returns nil when it's open.
+func (l *Loan) closedLoanError(ctx context.Context, id int) error {
+	loan, err := l.GetOne(ctx, id)
+	if err != nil {
+		return err
+	}
+	if loan.ReturnedAt != nil {
+		return &Error{Kind: ErrConflict, Code: "loan_returned", Message: "the loan has already been returned"}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Kinds of failure reported by the data layer. Every error returned from this
+// package that isn't an unexpected database or system failure wraps one of
+// these, so callers can branch with errors.Is.
+var (
+	ErrNotFound     = errors.New("record not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Error is a classified failure. Code is a stable, machine-readable identifier
+// such as "book_not_found", and Message is safe to show to API clients. Err
+// holds the underlying cause, if any, and is meant for logs only.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// FieldErrors reports invalid input, as a list of messages for each offending
+// field keyed by its JSON name.
+type FieldErrors map[string][]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+func (e FieldErrors) Unwrap() error {
+	return ErrValidation
+}
+
+// constraintErrors describes how violations of named database constraints are
+// reported to callers.
+var constraintErrors = map[string]Error{
+	"users_email_live_key":             {Kind: ErrConflict, Code: "email_taken", Message: "another user, not in the trash, already has this email address"},
+	"books_slug_key":                   {Kind: ErrConflict, Code: "slug_taken", Message: "another book already uses this slug"},
+	"books_isbn13_key":                 {Kind: ErrConflict, Code: "isbn_taken", Message: "another book, possibly in the trash, already has this ISBN"},
+	"books_oclc_key":                   {Kind: ErrConflict, Code: "oclc_taken", Message: "another book, possibly in the trash, already has this OCLC number"},
+	"books_lccn_key":                   {Kind: ErrConflict, Code: "lccn_taken", Message: "another book, possibly in the trash, already has this LCCN"},
+	"books_doi_key":                    {Kind: ErrConflict, Code: "doi_taken", Message: "another book, possibly in the trash, already has this DOI"},
+	"series_slug_key":                  {Kind: ErrConflict, Code: "slug_taken", Message: "another series already uses this slug"},
+	"book_series_position_key":         {Kind: ErrConflict, Code: "position_taken", Message: "another book already has this position in the series"},
+	"books_work_id_fkey":               {Kind: ErrValidation, Code: "work_not_found", Message: "the work does not exist"},
+	"publishers_name_key":              {Kind: ErrConflict, Code: "name_taken", Message: "a publisher with this name already exists"},
+	"imprints_publisher_id_name_key":   {Kind: ErrConflict, Code: "name_taken", Message: "the publisher already has an imprint with this name"},
+	"books_publisher_id_fkey":          {Kind: ErrValidation, Code: "publisher_not_found", Message: "the publisher does not exist"},
+	"books_imprint_id_fkey":            {Kind: ErrValidation, Code: "imprint_not_found", Message: "the imprint does not exist"},
+	"copies_barcode_key":               {Kind: ErrConflict, Code: "barcode_taken", Message: "another copy already has this barcode"},
+	"loans_copy_id_open_key":           {Kind: ErrConflict, Code: "copy_unavailable", Message: "the copy is already on loan"},
+	"holds_book_id_user_id_active_key": {Kind: ErrConflict, Code: "hold_exists", Message: "the user already has a hold on this book"},
+	"books_author_id_fkey":             {Kind: ErrValidation, Code: "author_not_found", Message: "the author does not exist"},
+	"books_genres_genre_id_fkey":       {Kind: ErrValidation, Code: "genre_not_found", Message: "one or more genres do not exist"},
+}
+
+// wrapError classifies err, as returned by database/sql, into one of the error
+// kinds above. entity names the record being worked on, e.g. "book", and is
+// used to build codes for missing rows. Errors that can't be classified are
+// returned unchanged.
+func wrapError(err error, entity string) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound(entity, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if known, ok := constraintErrors[pgErr.ConstraintName]; ok {
+			known.Err = err
+			return &known
+		}
+
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return &Error{Kind: ErrConflict, Code: entity + "_exists", Message: entity + " already exists", Err: err}
+		case "23503": // foreign_key_violation
+			return &Error{Kind: ErrValidation, Code: "invalid_reference", Message: "a referenced record does not exist", Err: err}
+		}
+	}
+
+	return err
+}
+
+// notFound reports that the entity being looked up does not exist.
+func notFound(entity string, err error) error {
+	return &Error{Kind: ErrNotFound, Code: entity + "_not_found", Message: entity + " not found", Err: err}
+}
+
+// editConflict reports an update based on a version of the entity that has
+// since been replaced.
+func editConflict(entity string) error {
+	return &Error{Kind: ErrConflict, Code: "edit_conflict", Message: entity + " was changed by someone else, reload it and try again"}
+}
+
+// unauthorized reports a request that failed authentication.
+func unauthorized(code, message string) error {
+	return &Error{Kind: ErrUnauthorized, Code: code, Message: message}
+}
+
+// requireRows reports the entity as not found when a statement that should
+// have changed a row didn't change any.
+func requireRows(res sql.Result, entity string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound(entity, nil)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Fine keeps the ledger of what users owe: fines accrued by overdue loans,
+// and the payments and waivers librarians record against them.
+type Fine struct{}
+
+// LedgerEntry is one line of a user's ledger. Amounts are in cents; fines are
+// positive and payments and waivers negative, so the balance is their sum.
+type LedgerEntry struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	LoanID     int       `json:"loan_id,omitempty"`
+	Kind       string    `json:"kind"`
+	Amount     int       `json:"amount"`
+	Note       string    `json:"note"`
+	RecordedBy int       `json:"recorded_by,omitempty"` // the librarian, for payments and waivers
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"` // fines are updated as they grow
+}
+
+// Kinds of ledger entries.
+const (
+	LedgerFine    = "fine"
+	LedgerPayment = "payment"
+	LedgerWaiver  = "waiver"
+)
+
+// Ledger is everything a user owes and has paid or been let off.
+type Ledger struct {
+	UserID  int            `json:"user_id"`
+	Balance int            `json:"balance"` // owed, in cents
+	Entries []*LedgerEntry `json:"entries"` // newest first
+}
+
+// FinePolicy says how much overdue loans cost.
+type FinePolicy struct {
+	PerDay     int           // in cents, for each day late
+	Grace      time.Duration // late by less than this costs nothing, and isn't counted
+	MaxPerLoan int           // in cents
+}
+
+// FlagOverdue marks the open loans that went past their due date since the
+// last call, and returns their ids.
+func (f *Fine) FlagOverdue(ctx context.Context) ([]int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update loans set overdue_at = now(), updated_at = now()
+			where returned_at is null and due_at < now() and overdue_at is null
+			returning id`
+
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Accrue brings the fines of overdue loans up to date with policy and returns
+// how many changed: of every open loan when loanID is 0, otherwise of that
+// loan only, returned or not, which settles its fine once it's back. Fines
+// only grow; a lower policy doesn't reduce what was already charged.
+func (f *Fine) Accrue(ctx context.Context, policy FinePolicy, loanID int) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	if policy.PerDay == 0 {
+		return 0, nil
+	}
+
+	stmt := `insert into ledger_entries (user_id, loan_id, kind, amount, note)
+			select l.user_id, l.id, 'fine',
+				least($3, $1 * ceil(extract(epoch from coalesce(l.returned_at, now()) - l.due_at - make_interval(secs => $2)) / 86400))::integer,
+				'Overdue: ' || b.title
+			from loans l
+			join copies c on (c.id = l.copy_id)
+			join books b on (b.id = c.book_id)
+			where (($4 = 0 and l.returned_at is null) or l.id = $4)
+			and coalesce(l.returned_at, now()) > l.due_at + make_interval(secs => $2)
+			on conflict (loan_id) where kind = 'fine' do update
+			set amount = excluded.amount, updated_at = now()
+			where excluded.amount > ledger_entries.amount`
+
+	res, err := db.ExecContext(ctx, stmt, policy.PerDay, policy.Grace.Seconds(), policy.MaxPerLoan, loanID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Ledger returns the balance and entries of the user with the given id.
+func (f *Fine) Ledger(ctx context.Context, userID int) (*Ledger, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, `select exists(select 1 from users where id = $1 and deleted_at is null)`, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, notFound("user", sql.ErrNoRows)
+	}
+
+	query := `select id, user_id, coalesce(loan_id, 0), kind, amount, note, coalesce(recorded_by, 0), created_at, updated_at
+			from ledger_entries
+			where user_id = $1
+			order by created_at desc, id desc`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ledger := &Ledger{UserID: userID, Entries: []*LedgerEntry{}}
+	for rows.Next() {
+		var e LedgerEntry
+		err := rows.Scan(&e.ID, &e.UserID, &e.LoanID, &e.Kind, &e.Amount, &e.Note, &e.RecordedBy, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		ledger.Balance += e.Amount
+		ledger.Entries = append(ledger.Entries, &e)
+	}
+
+	return ledger, rows.Err()
+}
+
+// Record adds a payment or waiver of entry.Amount, given as a positive number
+// of cents, to the ledger of entry.UserID and returns its id. It can't take
+// the balance below zero: the user's row is locked while the balance is
+// checked, so two payments at once can't both be taken from the same debt. A
+// waiver can name the loan whose fine it's for.
+func (f *Fine) Record(ctx context.Context, entry LedgerEntry) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `select id from users where id = $1 for update`, entry.UserID).Scan(&userID)
+	if err != nil {
+		return 0, wrapError(err, "user")
+	}
+
+	if entry.LoanID != 0 {
+		var ok bool
+		query := `select exists(select 1 from loans where id = $1 and user_id = $2)`
+		err := tx.QueryRowContext(ctx, query, entry.LoanID, entry.UserID).Scan(&ok)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, FieldErrors{"loan_id": {"must be a loan of the user"}}
+		}
+	}
+
+	stmt := `insert into ledger_entries (user_id, loan_id, kind, amount, note, recorded_by)
+			select $1, nullif($2, 0), $3, -$4::integer, $5, nullif($6, 0)
+			where (select coalesce(sum(amount), 0) from ledger_entries where user_id = $1) >= $4
+			returning id`
+
+	var id int
+	err = tx.QueryRowContext(ctx, stmt, entry.UserID, entry.LoanID, entry.Kind, entry.Amount, entry.Note, entry.RecordedBy).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, &Error{Kind: ErrConflict, Code: "exceeds_balance", Message: "the amount is more than the user owes"}
+	}
+	if err != nil {
+		return 0, wrapError(err, "ledger_entry")
+	}
+
+	return id, tx.Commit()
+}
+
+// balanceOf returns what the user with the given id owes, in cents.
+func balanceOf(ctx context.Context, userID int) (int, error) {
+	var balance int
+	err := db.QueryRowContext(ctx, `select coalesce(sum(amount), 0) from ledger_entries where user_id = $1`, userID).Scan(&balance)
+	return balance, err
+}
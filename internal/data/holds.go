@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Hold queues a user for a copy of a book. Holds wait in the order they were
+// placed until a copy is returned, when the first is made ready with the copy
+// put aside for the user to pick up before the hold expires.
+type Hold struct {
+	ID        int        `json:"id"`
+	BookID    int        `json:"book_id"`
+	Title     string     `json:"title"`
+	UserID    int        `json:"user_id"`
+	Email     string     `json:"-"` // of the user, for notifications
+	FirstName string     `json:"-"`
+	Status    string     `json:"status"`
+	Position  int        `json:"position,omitempty"` // in the queue for the book, while waiting
+	CopyID    int        `json:"copy_id,omitempty"`  // put aside for the user, once ready
+	Barcode   string     `json:"barcode,omitempty"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // the end of the pickup window
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Statuses of a hold. Waiting and ready holds are active; the others are
+// kept as history.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled" // the copy was checked out
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired" // the copy wasn't picked up in time
+)
+
+// HoldStatuses are the statuses holds can be listed by.
+var HoldStatuses = []string{HoldWaiting, HoldReady, HoldFulfilled, HoldCancelled, HoldExpired}
+
+// HoldFilter narrows down the holds listed by Hold.All. Zero values don't
+// filter.
+type HoldFilter struct {
+	UserID int
+	BookID int
+	Status string // one of HoldStatuses
+}
+
+// holdQuery selects the columns scanHold reads, for holds as h.
+const holdQuery = `select h.id, h.book_id, b.title, h.user_id, u.email, u.first_name, h.status,
+			case when h.status = 'waiting' then
+				(select count(*) from holds w where w.book_id = h.book_id and w.status = 'waiting'
+				and (w.created_at, w.id) <= (h.created_at, h.id))
+			else 0 end,
+			h.copy_id, coalesce(c.barcode, ''), h.ready_at, h.expires_at, h.created_at, h.updated_at
+			from holds h
+			join books b on (b.id = h.book_id)
+			join users u on (u.id = h.user_id)
+			left join copies c on (c.id = h.copy_id)`
+
+func scanHold(row interface{ Scan(...any) error }) (*Hold, error) {
+	var hold Hold
+	var copyID sql.NullInt64
+	var ready, expires sql.NullTime
+
+	err := row.Scan(&hold.ID, &hold.BookID, &hold.Title, &hold.UserID, &hold.Email, &hold.FirstName, &hold.Status,
+		&hold.Position, &copyID, &hold.Barcode, &ready, &expires, &hold.CreatedAt, &hold.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.CopyID = int(copyID.Int64)
+	if ready.Valid {
+		hold.ReadyAt = &ready.Time
+	}
+	if expires.Valid {
+		hold.ExpiresAt = &expires.Time
+	}
+
+	return &hold, nil
+}
+
+// All returns the holds that pass filter, oldest first, which is queue order
+// for the waiting holds of a book.
+func (h *Hold) All(ctx context.Context, filter HoldFilter) ([]*Hold, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := holdQuery + ` where ($1 = 0 or h.user_id = $1) and ($2 = 0 or h.book_id = $2) and ($3 = '' or h.status = $3)
+			order by h.created_at, h.id`
+
+	rows, err := db.QueryContext(ctx, query, filter.UserID, filter.BookID, filter.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	return holds, rows.Err()
+}
+
+// GetOne returns the hold with the given id.
+func (h *Hold) GetOne(ctx context.Context, id int) (*Hold, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	hold, err := scanHold(db.QueryRowContext(ctx, holdQuery+` where h.id = $1`, id))
+	if err != nil {
+		return nil, wrapError(err, "hold")
+	}
+
+	return hold, nil
+}
+
+// Place queues the user with the given id for the book with the given id and
+// returns the id of the hold. The book must have copies in circulation, and
+// the user must be active and not already have the book out.
+func (h *Hold) Place(ctx context.Context, bookID, userID int) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	var active int
+	err := db.QueryRowContext(ctx, `select user_active from users where id = $1 and deleted_at is null`, userID).Scan(&active)
+	if err != nil {
+		return 0, wrapError(err, "user")
+	}
+	if active != 1 {
+		return 0, &Error{Kind: ErrConflict, Code: "user_inactive", Message: "inactive users can't place holds"}
+	}
+
+	query := `select (select count(*) from copies where book_id = b.id and status in ('available', 'on_loan', 'on_hold')),
+				exists(select 1 from loans l join copies c on (c.id = l.copy_id)
+					where c.book_id = b.id and l.user_id = $2 and l.returned_at is null)
+			from books b
+			where b.id = $1 and b.deleted_at is null`
+
+	var copies int
+	var borrowed bool
+	err = db.QueryRowContext(ctx, query, bookID, userID).Scan(&copies, &borrowed)
+	if err != nil {
+		return 0, wrapError(err, "book")
+	}
+	if copies == 0 {
+		return 0, &Error{Kind: ErrConflict, Code: "book_not_circulating", Message: "the book has no copies that can be lent out"}
+	}
+	if borrowed {
+		return 0, &Error{Kind: ErrConflict, Code: "already_borrowed", Message: "the user already has a copy of the book out"}
+	}
+
+	var id int
+	err = db.QueryRowContext(ctx, `insert into holds (book_id, user_id) values ($1, $2) returning id`, bookID, userID).Scan(&id)
+	if err != nil {
+		return 0, wrapError(err, "hold")
+	}
+
+	return id, nil
+}
+
+// Allocate puts the copy with the given id aside for the first waiting hold
+// on its book, if the copy is available and anyone is waiting, and returns
+// the id of the hold, which is ready until window from now. It returns 0 when
+// the copy stays available.
+func (h *Hold) Allocate(ctx context.Context, copyID int, window time.Duration) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	// holds of users who were deactivated or deleted while waiting are
+	// skipped, and stay waiting in case they come back
+	stmt := `with next as (
+				select h.id from holds h
+				join copies c on (c.book_id = h.book_id)
+				join users u on (u.id = h.user_id)
+				where c.id = $1 and c.status = 'available' and h.status = 'waiting'
+				and u.user_active = 1 and u.deleted_at is null
+				order by h.created_at, h.id
+				limit 1
+				for update of h skip locked),
+			held as (
+				update copies set status = 'on_hold', updated_at = now()
+				where id = $1 and status = 'available' and exists(select 1 from next)
+				returning id)
+			update holds set status = 'ready', copy_id = held.id, ready_at = now(), expires_at = $2, updated_at = now()
+			from next, held
+			where holds.id = next.id
+			returning holds.id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, copyID, time.Now().Add(window)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, wrapError(err, "hold")
+	}
+
+	return id, nil
+}
+
+// Cancel cancels the waiting or ready hold with the given id. It returns the
+// id of the copy put aside for a ready hold, which is available again and can
+// be allocated to the next in line, or 0; waiting holds have no copy yet.
+func (h *Hold) Cancel(ctx context.Context, id int) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `with cancelled as (
+				update holds set status = 'cancelled', updated_at = now()
+				where id = $1 and status in ('waiting', 'ready')
+				returning copy_id),
+			freed as (
+				update copies set status = 'available', updated_at = now()
+				from cancelled where copies.id = cancelled.copy_id and copies.status = 'on_hold')
+			select coalesce(copy_id, 0) from cancelled`
+
+	var copyID int
+	err := db.QueryRowContext(ctx, stmt, id).Scan(&copyID)
+	if err == nil {
+		return copyID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	hold, err := h.GetOne(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &Error{Kind: ErrConflict, Code: "hold_closed", Message: "the hold is already " + hold.Status}
+}
+
+// Expire ends the ready holds whose pickup window closed before now, making
+// their copies available again, and returns their ids.
+func (h *Hold) Expire(ctx context.Context, now time.Time) ([]int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `with expired as (
+				update holds set status = 'expired', updated_at = now()
+				where status = 'ready' and expires_at < $1
+				returning id, copy_id),
+			freed as (
+				update copies set status = 'available', updated_at = now()
+				from expired where copies.id = expired.copy_id and copies.status = 'on_hold')
+			select id from expired order by id`
+
+	rows, err := db.QueryContext(ctx, stmt, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// CopiesToAllocate returns the available copies of books that have waiting
+// holds, of the book with the given id or, when it's 0, of every book.
+func (h *Hold) CopiesToAllocate(ctx context.Context, bookID int) ([]int, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select c.id from copies c
+			where c.status = 'available' and ($1 = 0 or c.book_id = $1)
+			and exists(select 1 from holds h where h.book_id = c.book_id and h.status = 'waiting')
+			order by c.id`
+
+	rows, err := db.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
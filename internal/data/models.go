@@ -14,26 +14,77 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-const dbTimeout = time.Second * 3
+// Timeouts bound database work. They apply on top of the caller's context, so
+// whichever ends first, a deadline or a client that went away, stops the query.
+type Timeouts struct {
+	Read  time.Duration // queries
+	Write time.Duration // inserts, updates and deletes
+}
+
+// timeouts are the defaults; New replaces them.
+var timeouts = Timeouts{Read: 3 * time.Second, Write: 5 * time.Second}
 
 var db *sql.DB
 
-func New(dbPool *sql.DB) Models {
+// querier runs statements, on the pool or in a transaction when several must
+// succeed or fail together.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func New(dbPool *sql.DB, t Timeouts) Models {
 	db = dbPool
+	timeouts = t
 
 	return Models{
 		User:   User{},
 		Token:  Token{},
 		Book:   Book{},
 		Author: Author{},
+		Genre:  Genre{},
+		Schema: Schema{},
+		Trash:  Trash{},
+		Series: Series{},
+		Work:   Work{},
+
+		Publisher: Publisher{},
+		Copy:      Copy{},
+		Loan:      Loan{},
+		Hold:      Hold{},
+		Fine:      Fine{},
+
+		BookRevision: BookRevision{},
 	}
 }
 
+func readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeouts.Read)
+}
+
+func writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeouts.Write)
+}
+
 type Models struct {
 	User   User
 	Token  Token
 	Book   Book
 	Author Author
+	Genre  Genre
+	Schema Schema
+	Trash  Trash
+	Series Series
+	Work   Work
+
+	Publisher Publisher
+	Copy      Copy
+	Loan      Loan
+	Hold      Hold
+	Fine      Fine
+
+	BookRevision BookRevision
 }
 
 type User struct {
@@ -45,19 +96,21 @@ type User struct {
 	Active    int       `json:"active"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"` // bumped by every update
+	Staff     bool      `json:"staff"`   // acts on behalf of other users, granted in the database
 	Token     Token     `json:"token"`
 }
 
-func (u *User) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at, 
+	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at, version, staff,
       case 
           when(select  count(id) from tokens t where user_id = users.id and t.expiry > now()) > 0 then 1
 		else 0
 	  end as has_token
-       from users order by last_name`
+       from users where deleted_at is null order by last_name`
 
 	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
@@ -78,6 +131,8 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.Active,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Version,
+			&user.Staff,
 			&user.Token.ID,
 		)
 		if err != nil {
@@ -90,11 +145,21 @@ func (u *User) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Count returns the number of registered users, not counting the trash.
+func (u *User) Count(ctx context.Context) (int, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var n int
+	err := db.QueryRowContext(ctx, `select count(*) from users where deleted_at is null`).Scan(&n)
+	return n, err
+}
+
+func (u *User) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at, version, staff from users where email = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
@@ -108,20 +173,22 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&user.Staff,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "user")
 	}
 
 	return &user, nil
 }
 
-func (u *User) GetOne(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetOne(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at, version, staff from users where id = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
@@ -135,17 +202,22 @@ func (u *User) GetOne(id int) (*User, error) {
 		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&user.Staff,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "user")
 	}
 
 	return &user, nil
 }
 
-func (u *User) Update() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Update saves u. u.Version must match the stored version, so an edit of an
+// outdated copy fails with an edit_conflict error rather than overwriting
+// someone else's changes. u.Version is the new version afterwards.
+func (u *User) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	stmt := `update users set
@@ -153,56 +225,79 @@ func (u *User) Update() error {
 		first_name = $2,
 		last_name = $3,
 		user_active = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $5,
+		version = version + 1
+		where id = $6 and version = $7 and deleted_at is null
+		returning version
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
+	err := db.QueryRowContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
 		u.Active,
 		time.Now(),
 		u.ID,
-	)
+		u.Version,
+	).Scan(&u.Version)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = db.QueryRowContext(ctx, `select exists(select 1 from users where id = $1 and deleted_at is null)`, u.ID).Scan(&exists)
+		if err != nil {
+			return wrapError(err, "user")
+		}
+		if exists {
+			return editConflict("user")
+		}
+		return notFound("user", nil)
+	}
 	if err != nil {
-		return err
+		return wrapError(err, "user")
 	}
 
 	return nil
 }
 
-func (u *User) Delete() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+func (u *User) Delete(ctx context.Context) error {
+	return u.DeleteByID(ctx, u.ID)
+}
 
-	stmt := `delete from users where id = $1`
+// DeleteByID moves the user to the trash and signs them out, both or neither.
+// They can be restored until the trash is purged, but will have to sign in
+// again. Their email address is free for a new account meanwhile.
+func (u *User) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
 
-	_, err := db.ExecContext(ctx, stmt, u.ID)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
-}
+	stmt := `update users set deleted_at = now(), updated_at = now() where id = $1 and deleted_at is null`
 
-func (u *User) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	res, err := tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
 
-	stmt := `delete from users where id = $1`
+	err = requireRows(res, "user")
+	if err != nil {
+		return err
+	}
 
-	_, err := db.ExecContext(ctx, stmt, id)
+	_, err = tx.ExecContext(ctx, `delete from tokens where user_id = $1`, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (u *User) Insert(user User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) Insert(ctx context.Context, user User) (int, error) {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
@@ -225,14 +320,14 @@ func (u *User) Insert(user User) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, wrapError(err, "user")
 	}
 
 	return newID, nil
 }
 
-func (u *User) ResetPassword(password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) ResetPassword(ctx context.Context, password string) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
@@ -240,13 +335,13 @@ func (u *User) ResetPassword(password string) error {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = db.ExecContext(ctx, stmt, hashedPassword, u.ID)
+	stmt := `update users set password = $1 where id = $2 and deleted_at is null`
+	res, err := db.ExecContext(ctx, stmt, hashedPassword, u.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return requireRows(res, "user")
 }
 
 func (u *User) PasswordMatches(plainText string) (bool, error) {
@@ -276,8 +371,8 @@ type Token struct {
 	Expiry    time.Time `json:"expiry"`
 }
 
-func (t *Token) GetByToken(plainText string) (*Token, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (t *Token) GetByToken(ctx context.Context, plainText string) (*Token, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
 	query := `select id, user_id, email, token, token_hash, created_at, updated_at, expiry
@@ -298,17 +393,17 @@ func (t *Token) GetByToken(plainText string) (*Token, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "token")
 	}
 
 	return &token, nil
 }
 
-func (t *Token) GetUserForToken(token Token) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (t *Token) GetUserForToken(ctx context.Context, token Token) (*User, error) {
+	ctx, cancel := readContext(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at, version, staff from users where id = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, token.UserID)
@@ -322,10 +417,12 @@ func (t *Token) GetUserForToken(token Token) (*User, error) {
 		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&user.Staff,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "user")
 	}
 
 	return &user, nil
@@ -350,47 +447,56 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	return token, nil
 }
 
-func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
-
+// BearerToken returns the plain text token from the Authorization header of r.
+func (t *Token) BearerToken(r *http.Request) (string, error) {
 	authorizationHeader := r.Header.Get("Authorization")
 	if authorizationHeader == "" {
-		return nil, errors.New("no authorization header received")
+		return "", unauthorized("missing_token", "no authorization header received")
 	}
 
 	headerParts := strings.Split(authorizationHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return nil, errors.New("no valid authorization header received")
+		return "", unauthorized("malformed_token", "no valid authorization header received")
 	}
 
 	token := headerParts[1]
 
 	if len(token) != 26 {
-		return nil, errors.New("token wrong size")
+		return "", unauthorized("malformed_token", "token wrong size")
+	}
+
+	return token, nil
+}
+
+func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
+	token, err := t.BearerToken(r)
+	if err != nil {
+		return nil, err
 	}
 
-	tkn, err := t.GetByToken(token)
+	tkn, err := t.GetByToken(r.Context(), token)
 	if err != nil {
-		return nil, errors.New("no matching token found")
+		return nil, unauthorized("invalid_token", "no matching token found")
 	}
 
 	if tkn.Expiry.Before(time.Now()) {
-		return nil, errors.New("expired token")
+		return nil, unauthorized("expired_token", "expired token")
 	}
 
-	user, err := t.GetUserForToken(*tkn)
+	user, err := t.GetUserForToken(r.Context(), *tkn)
 	if err != nil {
-		return nil, errors.New("no matching user found")
+		return nil, unauthorized("invalid_token", "no matching user found")
 	}
 
 	if user.Active == 0 {
-		return nil, errors.New("user not active")
+		return nil, &Error{Kind: ErrForbidden, Code: "user_inactive", Message: "user not active"}
 	}
 
 	return user, nil
 }
 
-func (t *Token) Insert(token Token, u User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (t *Token) Insert(ctx context.Context, token Token, u User) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	stmt := `delete from tokens where user_id = $1`
@@ -420,8 +526,8 @@ func (t *Token) Insert(token Token, u User) error {
 	return nil
 }
 
-func (t *Token) DeleteByToken(plainText string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (t *Token) DeleteByToken(ctx context.Context, plainText string) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	stmt := `delete from tokens where token = $1`
@@ -434,26 +540,26 @@ func (t *Token) DeleteByToken(plainText string) error {
 	return nil
 }
 
-func (t *Token) ValidToken(plainText string) (bool, error) {
-	token, err := t.GetByToken(plainText)
+func (t *Token) ValidToken(ctx context.Context, plainText string) (bool, error) {
+	token, err := t.GetByToken(ctx, plainText)
 	if err != nil {
-		return false, errors.New("no matching token found")
+		return false, unauthorized("invalid_token", "no matching token found")
 	}
 
-	_, err = t.GetUserForToken(*token)
+	_, err = t.GetUserForToken(ctx, *token)
 	if err != nil {
-		return false, errors.New("no matching user found")
+		return false, unauthorized("invalid_token", "no matching user found")
 	}
 
 	if token.Expiry.Before(time.Now()) {
-		return false, errors.New("expired token")
+		return false, unauthorized("expired_token", "expired token")
 	}
 
 	return true, nil
 }
 
-func (t *Token) DeleteTokensForUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (t *Token) DeleteTokensForUser(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 
 	stmt := `delete from tokens where user_id = $1`
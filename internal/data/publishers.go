@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Publisher is a publishing house. Editions name their publisher and,
+// optionally, the imprint of it they were published under.
+type Publisher struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Books     int       `json:"books"` // editions published, not counting the trash
+	Imprints  []Imprint `json:"imprints"`
+}
+
+// Imprint is a name a publisher publishes under, such as Anchor of Knopf
+// Doubleday.
+type Imprint struct {
+	ID          int       `json:"id"`
+	PublisherID int       `json:"publisher_id"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// publisherQuery selects the columns scanPublisher reads, for publishers as p.
+const publisherQuery = `select p.id, p.name, p.created_at, p.updated_at,
+			(select count(*) from books b where b.publisher_id = p.id and b.deleted_at is null),
+			coalesce((select json_agg(json_build_object('id', i.id, 'publisher_id', i.publisher_id, 'name', i.name,
+					'created_at', i.created_at, 'updated_at', i.updated_at) order by i.name)
+				from imprints i where i.publisher_id = p.id), '[]')
+			from publishers p`
+
+func scanPublisher(row interface{ Scan(...any) error }) (*Publisher, error) {
+	var publisher Publisher
+	var imprints []byte
+
+	err := row.Scan(&publisher.ID, &publisher.Name, &publisher.CreatedAt, &publisher.UpdatedAt, &publisher.Books, &imprints)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(imprints, &publisher.Imprints)
+	if err != nil {
+		return nil, err
+	}
+
+	return &publisher, nil
+}
+
+// All returns every publisher with its imprints, by name.
+func (p *Publisher) All(ctx context.Context) ([]*Publisher, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, publisherQuery+` order by p.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	publishers := []*Publisher{}
+	for rows.Next() {
+		publisher, err := scanPublisher(rows)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+
+	return publishers, rows.Err()
+}
+
+// GetOne returns the publisher with the given id and its imprints.
+func (p *Publisher) GetOne(ctx context.Context, id int) (*Publisher, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	publisher, err := scanPublisher(db.QueryRowContext(ctx, publisherQuery+` where p.id = $1`, id))
+	if err != nil {
+		return nil, wrapError(err, "publisher")
+	}
+
+	return publisher, nil
+}
+
+// Insert adds a publisher and returns its id.
+func (p *Publisher) Insert(ctx context.Context, publisher Publisher) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `insert into publishers (name, created_at, updated_at) values ($1, $2, $2) returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, publisher.Name, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, wrapError(err, "publisher")
+	}
+
+	return id, nil
+}
+
+// Update renames the publisher.
+func (p *Publisher) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update publishers set name = $1, updated_at = $2 where id = $3`
+	res, err := db.ExecContext(ctx, stmt, p.Name, time.Now(), p.ID)
+	if err != nil {
+		return wrapError(err, "publisher")
+	}
+	return requireRows(res, "publisher")
+}
+
+// DeleteByID deletes the publisher and its imprints. Publishers of books,
+// including those in the trash, can't be deleted.
+func (p *Publisher) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	var books int
+	err := db.QueryRowContext(ctx, `select count(*) from books where publisher_id = $1`, id).Scan(&books)
+	if err != nil {
+		return err
+	}
+	if books > 0 {
+		return &Error{Kind: ErrConflict, Code: "publisher_has_books", Message: "the publisher still has books, possibly in the trash, move them to another publisher first"}
+	}
+
+	res, err := db.ExecContext(ctx, `delete from publishers where id = $1`, id)
+	if err != nil {
+		return wrapError(err, "publisher")
+	}
+	return requireRows(res, "publisher")
+}
+
+// Imprint returns the imprint with the given id.
+func (p *Publisher) Imprint(ctx context.Context, id int) (*Imprint, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select id, publisher_id, name, created_at, updated_at from imprints where id = $1`
+
+	var imprint Imprint
+	err := db.QueryRowContext(ctx, query, id).Scan(&imprint.ID, &imprint.PublisherID, &imprint.Name, &imprint.CreatedAt, &imprint.UpdatedAt)
+	if err != nil {
+		return nil, wrapError(err, "imprint")
+	}
+
+	return &imprint, nil
+}
+
+// InsertImprint adds an imprint to its publisher and returns its id.
+func (p *Publisher) InsertImprint(ctx context.Context, imprint Imprint) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `insert into imprints (publisher_id, name, created_at, updated_at) values ($1, $2, $3, $3) returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, imprint.PublisherID, imprint.Name, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, wrapError(err, "imprint")
+	}
+
+	return id, nil
+}
+
+// UpdateImprint renames an imprint of the publisher it names.
+func (p *Publisher) UpdateImprint(ctx context.Context, imprint Imprint) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update imprints set name = $1, updated_at = $2 where id = $3 and publisher_id = $4`
+	res, err := db.ExecContext(ctx, stmt, imprint.Name, time.Now(), imprint.ID, imprint.PublisherID)
+	if err != nil {
+		return wrapError(err, "imprint")
+	}
+	return requireRows(res, "imprint")
+}
+
+// DeleteImprint deletes an imprint of the publisher with the given id.
+// Imprints of books, including those in the trash, can't be deleted.
+func (p *Publisher) DeleteImprint(ctx context.Context, publisherID, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	var books int
+	err := db.QueryRowContext(ctx, `select count(*) from books where imprint_id = $1`, id).Scan(&books)
+	if err != nil {
+		return err
+	}
+	if books > 0 {
+		return &Error{Kind: ErrConflict, Code: "imprint_has_books", Message: "the imprint still has books, possibly in the trash, move them to another imprint first"}
+	}
+
+	res, err := db.ExecContext(ctx, `delete from imprints where id = $1 and publisher_id = $2`, id, publisherID)
+	if err != nil {
+		return wrapError(err, "imprint")
+	}
+	return requireRows(res, "imprint")
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// BookRevision is a book as it was saved at one version.
+type BookRevision struct {
+	ID              int           `json:"id"`
+	BookID          int           `json:"book_id"`
+	Version         int           `json:"version"`
+	Title           string        `json:"title"`
+	AuthorID        int           `json:"author_id"`
+	PublicationYear int           `json:"publication_year"`
+	Description     string        `json:"description"`
+	GenreIDs        []int         `json:"genre_ids"`
+	Contributors    []Contributor `json:"contributors"`
+	ISBN13          string        `json:"isbn13"`
+	OCLC            string        `json:"oclc"`
+	LCCN            string        `json:"lccn"`
+	DOI             string        `json:"doi"`
+	WorkID          int           `json:"work_id,omitempty"` // empty for revisions from before works
+	Format          string        `json:"format"`
+	PublisherID     int           `json:"publisher_id"`
+	ImprintID       int           `json:"imprint_id"`
+	PageCount       int           `json:"page_count"`
+	Language        string        `json:"language"`
+	CoverKey        string        `json:"cover_key"`           // empty before covers were tracked
+	EditorID        int           `json:"editor_id,omitempty"` // zero when unknown, or the editor was purged
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+// FieldChange is a field that differs between two revisions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// recordRevision copies the book with the given id, as it is now, into its
+// history. Saving the same version twice records it once.
+func recordRevision(ctx context.Context, q querier, bookID, editorID int) error {
+	stmt := `insert into book_revisions (book_id, version, title, author_id, publication_year, description, genre_ids, contributors, cover_key, editor_id,
+				isbn13, oclc, lccn, doi, work_id, format, publisher_id, imprint_id, page_count, language)
+			select b.id, b.version, b.title, b.author_id, b.publication_year, coalesce(b.description, ''),
+				coalesce((select array_agg(g.genre_id order by g.genre_id) from books_genres g where g.book_id = b.id), '{}'),
+				coalesce((select jsonb_agg(jsonb_build_object('author_id', c.author_id, 'role', c.role, 'position', c.position) order by c.position)
+					from book_contributors c where c.book_id = b.id), '[]'),
+				b.cover_key, nullif($2, 0),
+				coalesce(b.isbn13, ''), coalesce(b.oclc, ''), coalesce(b.lccn, ''), coalesce(b.doi, ''),
+				b.work_id, b.format, b.publisher_id, b.imprint_id, b.page_count, b.language
+			from books b where b.id = $1
+			on conflict (book_id, version) do nothing`
+
+	_, err := q.ExecContext(ctx, stmt, bookID, editorID)
+	return err
+}
+
+// All returns the revisions of a book, newest first.
+func (r *BookRevision) All(ctx context.Context, bookID int) ([]*BookRevision, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := revisionQuery + ` where book_id = $1 order by version desc`
+
+	rows, err := db.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*BookRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Get returns the revision of a book saved at version.
+func (r *BookRevision) Get(ctx context.Context, bookID, version int) (*BookRevision, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := revisionQuery + ` where book_id = $1 and version = $2`
+
+	rev, err := scanRevision(db.QueryRowContext(ctx, query, bookID, version))
+	if err != nil {
+		return nil, wrapError(err, "revision")
+	}
+
+	return rev, nil
+}
+
+// Diff lists the fields that changed from r to other, in a fixed order.
+func (r *BookRevision) Diff(other *BookRevision) []FieldChange {
+	changes := []FieldChange{}
+	add := func(field string, from, to any, same bool) {
+		if !same {
+			changes = append(changes, FieldChange{Field: field, From: from, To: to})
+		}
+	}
+
+	add("title", r.Title, other.Title, r.Title == other.Title)
+	add("author_id", r.AuthorID, other.AuthorID, r.AuthorID == other.AuthorID)
+	add("publication_year", r.PublicationYear, other.PublicationYear, r.PublicationYear == other.PublicationYear)
+	add("description", r.Description, other.Description, r.Description == other.Description)
+	add("genre_ids", r.GenreIDs, other.GenreIDs, slices.Equal(r.GenreIDs, other.GenreIDs))
+	add("contributors", r.Contributors, other.Contributors, slices.Equal(r.Contributors, other.Contributors))
+	add("isbn13", r.ISBN13, other.ISBN13, r.ISBN13 == other.ISBN13)
+	add("oclc", r.OCLC, other.OCLC, r.OCLC == other.OCLC)
+	add("lccn", r.LCCN, other.LCCN, r.LCCN == other.LCCN)
+	add("doi", r.DOI, other.DOI, r.DOI == other.DOI)
+	add("work_id", r.WorkID, other.WorkID, r.WorkID == other.WorkID)
+	add("format", r.Format, other.Format, r.Format == other.Format)
+	add("publisher_id", r.PublisherID, other.PublisherID, r.PublisherID == other.PublisherID)
+	add("imprint_id", r.ImprintID, other.ImprintID, r.ImprintID == other.ImprintID)
+	add("page_count", r.PageCount, other.PageCount, r.PageCount == other.PageCount)
+	add("language", r.Language, other.Language, r.Language == other.Language)
+	add("cover_key", r.CoverKey, other.CoverKey, r.CoverKey == other.CoverKey)
+
+	return changes
+}
+
+const revisionQuery = `select id, book_id, version, title, author_id, publication_year, description,
+			array_to_json(genre_ids), contributors, isbn13, oclc, lccn, doi,
+			coalesce(work_id, 0), format, coalesce(publisher_id, 0), coalesce(imprint_id, 0), page_count, language, cover_key, coalesce(editor_id, 0), created_at
+			from book_revisions`
+
+func scanRevision(row interface{ Scan(...any) error }) (*BookRevision, error) {
+	var rev BookRevision
+	var genreIDs, contributors []byte
+
+	err := row.Scan(
+		&rev.ID,
+		&rev.BookID,
+		&rev.Version,
+		&rev.Title,
+		&rev.AuthorID,
+		&rev.PublicationYear,
+		&rev.Description,
+		&genreIDs,
+		&contributors,
+		&rev.ISBN13,
+		&rev.OCLC,
+		&rev.LCCN,
+		&rev.DOI,
+		&rev.WorkID,
+		&rev.Format,
+		&rev.PublisherID,
+		&rev.ImprintID,
+		&rev.PageCount,
+		&rev.Language,
+		&rev.CoverKey,
+		&rev.EditorID,
+		&rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(genreIDs, &rev.GenreIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contributors, &rev.Contributors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rev, nil
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Schema reports on the database itself rather than any one table.
+type Schema struct{}
+
+// Ping checks the database can be reached.
+func (s *Schema) Ping(ctx context.Context) error {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// Version returns the migration the database was last migrated to, and whether
+// that migration failed half way. A database that was never migrated is at
+// version 0.
+func (s *Schema) Version(ctx context.Context) (int64, bool, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+
+	err := db.QueryRowContext(ctx, `select version, dirty from schema_migrations limit 1`).Scan(&version, &dirty)
+
+	var pgErr *pgconn.PgError
+	if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == "42P01") {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// Stats returns the connection pool statistics.
+func (s *Schema) Stats() sql.DBStats {
+	return db.Stats()
+}
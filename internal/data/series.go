@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mozillazg/go-slugify"
+)
+
+// Series is an ordered run of books, such as The Dark Tower.
+type Series struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Slug        string       `json:"slug"`
+	Description string       `json:"description"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Books       []SeriesBook `json:"books,omitempty"` // in reading order, when showing one series
+}
+
+// SeriesBook is a book in a series. Position is its number in the series,
+// which can be fractional, e.g. 4.5 for a novella between books 4 and 5.
+type SeriesBook struct {
+	BookID          int     `json:"book_id"`
+	Title           string  `json:"title"`
+	Slug            string  `json:"slug"`
+	PublicationYear int     `json:"publication_year"`
+	Position        float64 `json:"position"`
+}
+
+// BookSeries is a book's place in a series, with the books either side of it.
+type BookSeries struct {
+	SeriesID  int         `json:"series_id"`
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	Position  float64     `json:"position"`
+	Previous  *SeriesBook `json:"previous"` // nil for the first book
+	Next      *SeriesBook `json:"next"`     // nil for the last book
+	UpdatedAt time.Time   `json:"-"`        // of the series, which changes with its books
+}
+
+// seriesNeighbour selects, as JSON, the book next to bs in its series in the
+// direction given by the comparison and order filled in by fmt.
+const seriesNeighbour = `(select json_build_object('book_id', b.id, 'title', b.title, 'slug', b.slug,
+				'publication_year', b.publication_year, 'position', o.position)
+			from book_series o
+			join books b on (b.id = o.book_id)
+			where o.series_id = bs.series_id and o.position %s bs.position and b.deleted_at is null
+			order by o.position %s
+			limit 1)`
+
+// seriesForBook returns the series the book with the given id belongs to, by
+// name, with its neighbours in each.
+func seriesForBook(ctx context.Context, id int) ([]BookSeries, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select s.id, s.name, s.slug, s.updated_at, bs.position,
+			` + fmt.Sprintf(seriesNeighbour, "<", "desc") + `,
+			` + fmt.Sprintf(seriesNeighbour, ">", "asc") + `
+			from book_series bs
+			join series s on (s.id = bs.series_id)
+			where bs.book_id = $1
+			order by s.name`
+
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []BookSeries
+	for rows.Next() {
+		var bs BookSeries
+		var previous, next []byte
+		err := rows.Scan(&bs.SeriesID, &bs.Name, &bs.Slug, &bs.UpdatedAt, &bs.Position, &previous, &next)
+		if err != nil {
+			return nil, err
+		}
+
+		bs.Previous, err = unmarshalSeriesBook(previous)
+		if err != nil {
+			return nil, err
+		}
+		bs.Next, err = unmarshalSeriesBook(next)
+		if err != nil {
+			return nil, err
+		}
+
+		series = append(series, bs)
+	}
+
+	return series, rows.Err()
+}
+
+// unmarshalSeriesBook decodes a book selected by seriesNeighbour, returning nil
+// when there was none.
+func unmarshalSeriesBook(raw []byte) (*SeriesBook, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	var book SeriesBook
+	err := json.Unmarshal(raw, &book)
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// All returns every series, by name.
+func (s *Series) All(ctx context.Context) ([]*Series, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select id, name, slug, description, created_at, updated_at from series order by name`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := []*Series{}
+	for rows.Next() {
+		var series Series
+		err := rows.Scan(&series.ID, &series.Name, &series.Slug, &series.Description, &series.CreatedAt, &series.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, &series)
+	}
+
+	return all, rows.Err()
+}
+
+// GetBySlug returns the series with the given slug and its books in order,
+// leaving out books in the trash.
+func (s *Series) GetBySlug(ctx context.Context, slug string) (*Series, error) {
+	return s.one(ctx, `slug = $1`, slug)
+}
+
+// GetByID returns the series with the given id, like GetBySlug.
+func (s *Series) GetByID(ctx context.Context, id int) (*Series, error) {
+	return s.one(ctx, `id = $1`, id)
+}
+
+// one returns the series where condition holds, with its books.
+func (s *Series) one(ctx context.Context, condition string, args ...any) (*Series, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	query := `select id, name, slug, description, created_at, updated_at from series where ` + condition
+
+	var series Series
+	err := db.QueryRowContext(ctx, query, args...).Scan(&series.ID, &series.Name, &series.Slug, &series.Description, &series.CreatedAt, &series.UpdatedAt)
+	if err != nil {
+		return nil, wrapError(err, "series")
+	}
+
+	query = `select b.id, b.title, b.slug, b.publication_year, bs.position
+			from book_series bs
+			join books b on (b.id = bs.book_id)
+			where bs.series_id = $1 and b.deleted_at is null
+			order by bs.position`
+
+	rows, err := db.QueryContext(ctx, query, series.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series.Books = []SeriesBook{}
+	for rows.Next() {
+		var book SeriesBook
+		err := rows.Scan(&book.BookID, &book.Title, &book.Slug, &book.PublicationYear, &book.Position)
+		if err != nil {
+			return nil, err
+		}
+		series.Books = append(series.Books, book)
+	}
+
+	return &series, rows.Err()
+}
+
+// Insert adds a series, with a slug made from its name unless it has one, and
+// returns its id.
+func (s *Series) Insert(ctx context.Context, series Series) (int, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	if series.Slug == "" {
+		series.Slug = slugify.Slugify(series.Name)
+		if series.Slug == "" {
+			return 0, FieldErrors{"slug": {"must be provided when the name has no letters or digits"}}
+		}
+	}
+
+	stmt := `insert into series (name, slug, description, created_at, updated_at)
+			values ($1, $2, $3, $4, $4) returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, series.Name, series.Slug, series.Description, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, wrapError(err, "series")
+	}
+
+	return id, nil
+}
+
+// Update saves the name, slug and description of the series. An empty slug
+// keeps the current one, so links to the series keep working.
+func (s *Series) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update series set name = $1, slug = coalesce(nullif($2, ''), slug), description = $3, updated_at = $4
+			where id = $5`
+
+	res, err := db.ExecContext(ctx, stmt, s.Name, s.Slug, s.Description, time.Now(), s.ID)
+	if err != nil {
+		return wrapError(err, "series")
+	}
+	return requireRows(res, "series")
+}
+
+// DeleteByID deletes the series. Its books are kept.
+func (s *Series) DeleteByID(ctx context.Context, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `delete from series where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRows(res, "series")
+}
+
+// SetBook puts the book with the given id at position in the series, moving it
+// there if it's already in the series.
+func (s *Series) SetBook(ctx context.Context, seriesID, bookID int, position float64) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `insert into book_series (series_id, book_id, position)
+			select $1, id, $3 from books where id = $2 and deleted_at is null
+			on conflict on constraint book_series_pkey do update set position = excluded.position`
+
+	res, err := db.ExecContext(ctx, stmt, seriesID, bookID, position)
+	if err != nil {
+		return wrapError(err, "series")
+	}
+	if err := requireRows(res, "book"); err != nil {
+		return err
+	}
+
+	return touchSeries(ctx, seriesID)
+}
+
+// RemoveBook takes the book with the given id out of the series.
+func (s *Series) RemoveBook(ctx context.Context, seriesID, bookID int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `delete from book_series where series_id = $1 and book_id = $2`, seriesID, bookID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &Error{Kind: ErrNotFound, Code: "book_not_in_series", Message: "the book is not in this series"}
+	}
+
+	return touchSeries(ctx, seriesID)
+}
+
+// touchSeries marks the series as changed, so the books in it, which show
+// their neighbours, are seen to change too.
+func touchSeries(ctx context.Context, id int) error {
+	_, err := db.ExecContext(ctx, `update series set updated_at = now() where id = $1`, id)
+	return err
+}
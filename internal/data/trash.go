@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// TrashItem is a deleted book, author or user waiting to be restored or purged.
+type TrashItem struct {
+	Type      string    `json:"type"` // book, author or user
+	ID        int       `json:"id"`
+	Name      string    `json:"name"` // the title, author name or email
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Trash holds deleted books, authors and users until they're purged.
+type Trash struct{}
+
+// TrashTypes are the types of record that are moved to the trash when deleted.
+var TrashTypes = []string{"book", "author", "user"}
+
+// trashTables maps each of TrashTypes to its table and the column naming a row.
+var trashTables = map[string]struct{ table, name string }{
+	"book":   {"books", "title"},
+	"author": {"authors", "author_name"},
+	"user":   {"users", "email"},
+}
+
+// Purged counts the records a purge deleted for good. The slugs of the books
+// among them are listed so their covers can be removed too.
+type Purged struct {
+	Books     int
+	Authors   int
+	Users     int
+	Kept      int // books and users due to go, kept for their loans or fines
+	BookSlugs []string
+}
+
+// List returns what's in the trash, most recently deleted first. typ limits the
+// list to one of TrashTypes; empty lists everything.
+func (t *Trash) List(ctx context.Context, typ string) ([]*TrashItem, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var parts []string
+	for _, name := range TrashTypes {
+		if typ != "" && typ != name {
+			continue
+		}
+		tbl := trashTables[name]
+		parts = append(parts, `select '`+name+`', id, `+tbl.name+`, deleted_at from `+tbl.table+` where deleted_at is not null`)
+	}
+	query := strings.Join(parts, " union all ") + " order by 4 desc, 1, 2"
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*TrashItem{}
+	for rows.Next() {
+		var item TrashItem
+		err := rows.Scan(&item.Type, &item.ID, &item.Name, &item.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	return items, rows.Err()
+}
+
+// Restore takes the record of type typ with the given id out of the trash. A
+// book can only be restored while none of its contributors are in the trash.
+func (t *Trash) Restore(ctx context.Context, typ string, id int) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	stmt := `update ` + trashTables[typ].table + ` set deleted_at = null, updated_at = now()
+			where id = $1 and deleted_at is not null`
+	if typ == "book" {
+		stmt += ` and not exists(select 1 from book_contributors c
+			join authors a on (a.id = c.author_id)
+			where c.book_id = books.id and a.deleted_at is not null)`
+	}
+
+	res, err := db.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return wrapError(err, typ)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	if typ == "book" {
+		var trashed bool
+		query := `select exists(select 1 from books where id = $1 and deleted_at is not null)`
+		err := db.QueryRowContext(ctx, query, id).Scan(&trashed)
+		if err != nil {
+			return err
+		}
+		if trashed {
+			return &Error{Kind: ErrConflict, Code: "author_trashed", Message: "an author of the book is in the trash, restore them first"}
+		}
+	}
+	return notFound(typ, nil)
+}
+
+// Purge deletes for good everything that went into the trash before cutoff,
+// all at once or not at all. Authors are kept while any book they contributed
+// to, trashed or not, still exists, and works go once their last edition does.
+// Books with copies out on loan, and users with loans or fines outstanding,
+// are kept until they're settled, as purging would lose track of them.
+func (t *Trash) Purge(ctx context.Context, cutoff time.Time) (Purged, error) {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	var purged Purged
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return purged, err
+	}
+	defer tx.Rollback()
+
+	stmt := `delete from books b where deleted_at < $1
+			and not exists(select 1 from copies c join loans l on (l.copy_id = c.id)
+				where c.book_id = b.id and l.returned_at is null)
+			returning slug`
+	rows, err := tx.QueryContext(ctx, stmt, cutoff)
+	if err != nil {
+		return purged, err
+	}
+
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			rows.Close()
+			return purged, err
+		}
+		purged.BookSlugs = append(purged.BookSlugs, slug)
+	}
+	if err := rows.Err(); err != nil {
+		return purged, err
+	}
+	purged.Books = len(purged.BookSlugs)
+
+	// works are kept for as long as they have editions
+	_, err = tx.ExecContext(ctx, `delete from works w where not exists(select 1 from books b where b.work_id = w.id)`)
+	if err != nil {
+		return purged, err
+	}
+
+	stmt = `delete from authors a where deleted_at < $1
+			and not exists(select 1 from books b where b.author_id = a.id)
+			and not exists(select 1 from book_contributors c where c.author_id = a.id)`
+	purged.Authors, err = execCount(ctx, tx, stmt, cutoff)
+	if err != nil {
+		return purged, err
+	}
+
+	stmt = `delete from users u where deleted_at < $1
+			and not exists(select 1 from loans l where l.user_id = u.id and l.returned_at is null)
+			and (select coalesce(sum(amount), 0) from ledger_entries e where e.user_id = u.id) = 0`
+	purged.Users, err = execCount(ctx, tx, stmt, cutoff)
+	if err != nil {
+		return purged, err
+	}
+
+	query := `select (select count(*) from books where deleted_at < $1) + (select count(*) from users where deleted_at < $1)`
+	err = tx.QueryRowContext(ctx, query, cutoff).Scan(&purged.Kept)
+	if err != nil {
+		return purged, err
+	}
+
+	return purged, tx.Commit()
+}
+
+// execCount runs stmt in tx and returns how many rows it affected.
+func execCount(ctx context.Context, tx *sql.Tx, stmt string, args ...any) (int, error) {
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Work is a book as written, such as The Stand, of which every row of books is
+// an edition. Editions have their own format, publisher, page count, language,
+// ISBN and cover.
+type Work struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Editions  []Edition `json:"editions"` // oldest first, not counting the trash
+}
+
+// Edition is a book as listed among the editions of its work.
+type Edition struct {
+	BookID          int       `json:"book_id"`
+	Title           string    `json:"title"`
+	Slug            string    `json:"slug"`
+	PublicationYear int       `json:"publication_year"`
+	Format          string    `json:"format,omitempty"`
+	Publisher       string    `json:"publisher,omitempty"` // the publisher's name
+	PageCount       int       `json:"page_count,omitempty"`
+	Language        string    `json:"language,omitempty"`
+	ISBN13          string    `json:"isbn13,omitempty"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+// GetByID returns the work with the given id and its editions.
+func (w *Work) GetByID(ctx context.Context, id int) (*Work, error) {
+	work, err := workByID(ctx, id)
+	if err != nil {
+		return nil, wrapError(err, "work")
+	}
+	return work, nil
+}
+
+// Exists reports whether a work with the given id exists.
+func (w *Work) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, `select exists(select 1 from works where id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Update renames the work.
+func (w *Work) Update(ctx context.Context) error {
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `update works set title = $1, updated_at = $2 where id = $3`, w.Title, time.Now(), w.ID)
+	if err != nil {
+		return err
+	}
+	return requireRows(res, "work")
+}
+
+// workByID returns the work with the given id and its editions.
+func workByID(ctx context.Context, id int) (*Work, error) {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	var work Work
+	query := `select id, title, created_at, updated_at from works where id = $1`
+	err := db.QueryRowContext(ctx, query, id).Scan(&work.ID, &work.Title, &work.CreatedAt, &work.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `select b.id, b.title, b.slug, b.publication_year, b.format, coalesce(p.name, ''), b.page_count, b.language,
+			coalesce(b.isbn13, ''), b.updated_at
+			from books b
+			left join publishers p on (p.id = b.publisher_id)
+			where b.work_id = $1 and b.deleted_at is null
+			order by b.publication_year, b.id`
+
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	work.Editions = []Edition{}
+	for rows.Next() {
+		var e Edition
+		err := rows.Scan(&e.BookID, &e.Title, &e.Slug, &e.PublicationYear, &e.Format, &e.Publisher, &e.PageCount, &e.Language,
+			&e.ISBN13, &e.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		work.Editions = append(work.Editions, e)
+	}
+
+	return &work, rows.Err()
+}
+
+// insertWork adds a work with the given title and returns its id.
+func insertWork(ctx context.Context, q querier, title string) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `insert into works (title) values ($1) returning id`, title).Scan(&id)
+	return id, err
+}
+
+// syncWorkTitle renames the work with the given id to title when it has a
+// single edition, which is then the only place the title comes from.
+func syncWorkTitle(ctx context.Context, q querier, id int, title string) error {
+	stmt := `update works set title = $2, updated_at = now()
+			where id = $1 and title <> $2
+			and (select count(*) from books where work_id = $1) = 1`
+
+	_, err := q.ExecContext(ctx, stmt, id, title)
+	return err
+}
+
+// deleteEmptyWork deletes the work with the given id if no book, in the trash
+// or not, is an edition of it anymore.
+func deleteEmptyWork(ctx context.Context, q querier, id int) error {
+	stmt := `delete from works where id = $1 and not exists(select 1 from books where work_id = $1)`
+
+	_, err := q.ExecContext(ctx, stmt, id)
+	return err
+}
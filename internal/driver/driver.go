@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type DB struct {
@@ -14,19 +16,30 @@ type DB struct {
 
 var dbConn = &DB{}
 
-const maxOpenDbConn = 5
-const maxIdleDbConn = 5
-const maxDbLifeTime = 5 * time.Minute
+// Options sizes the connection pool.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
 
-func ConnectPostgres(dsn string) (*DB, error) {
-	d, err := sql.Open("pgx", dsn)
+// ConnectPostgres opens a pool whose queries are traced with the global
+// OpenTelemetry tracer provider.
+func ConnectPostgres(dsn string, opts Options) (*DB, error) {
+	d, err := otelsql.Open("pgx", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifeTime)
+	d.SetMaxOpenConns(opts.MaxOpenConns)
+	d.SetMaxIdleConns(opts.MaxIdleConns)
+	d.SetConnMaxLifetime(opts.ConnMaxLifetime)
 
 	err = testDB(d)
 	if err != nil {
@@ -40,10 +53,8 @@ func ConnectPostgres(dsn string) (*DB, error) {
 func testDB(d *sql.DB) error {
 	err := d.Ping()
 	if err != nil {
-		fmt.Println("Error!", err)
-		return err
+		return fmt.Errorf("ping database: %w", err)
 	}
-	fmt.Println("*** Pinged database successfully! ***")
 
 	return nil
 }
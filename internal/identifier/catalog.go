@@ -0,0 +1,88 @@
+package identifier
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Errors returned for catalogue identifiers that can't be normalized.
+var (
+	ErrOCLC = errors.New("oclc number must be digits, optionally prefixed with ocm, ocn, on or (OCoLC)")
+	ErrLCCN = errors.New("lccn must be up to three letters followed by a year and serial number")
+	ErrDOI  = errors.New("doi must look like 10.1000/xyz")
+)
+
+// OCLC returns an OCLC control number as plain digits, without the prefixes
+// WorldCat and MARC records add or leading zeros. MARC records may put
+// (OCoLC) before a WorldCat prefix, as in (OCoLC)ocm12345678.
+func OCLC(number string) (string, error) {
+	n := trimPrefix(strings.TrimSpace(number), "(OCoLC)")
+	for _, prefix := range []string{"ocm", "ocn", "on"} {
+		if m := trimPrefix(n, prefix); m != n {
+			n = m
+			break
+		}
+	}
+
+	if !numeric(n) {
+		return "", ErrOCLC
+	}
+
+	n = strings.TrimLeft(n, "0")
+	if n == "" {
+		return "", ErrOCLC
+	}
+	return n, nil
+}
+
+// trimPrefix removes prefix from s regardless of case, along with any blanks
+// after it. s is returned unchanged when it doesn't start with prefix.
+func trimPrefix(s, prefix string) string {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return strings.TrimSpace(s[len(prefix):])
+	}
+	return s
+}
+
+var normalizedLCCN = regexp.MustCompile(`^[a-z]{0,3}(\d{8}|\d{10})$`)
+
+// LCCN returns a Library of Congress Control Number in its normalized form:
+// blanks and any revision suffix after a slash removed, and the serial number
+// after a hyphen padded to six digits.
+func LCCN(number string) (string, error) {
+	n := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(number), " ", ""))
+	n, _, _ = strings.Cut(n, "/")
+
+	if prefix, serial, ok := strings.Cut(n, "-"); ok {
+		if !numeric(serial) || len(serial) > 6 {
+			return "", ErrLCCN
+		}
+		n = prefix + strings.Repeat("0", 6-len(serial)) + serial
+	}
+
+	if !normalizedLCCN.MatchString(n) {
+		return "", ErrLCCN
+	}
+	return n, nil
+}
+
+var validDOI = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+
+// DOI returns a Digital Object Identifier without the resolver URL or "doi:"
+// prefix it's often written with. DOIs are case insensitive, and are stored
+// as given but compared regardless of case.
+func DOI(doi string) (string, error) {
+	d := strings.TrimSpace(doi)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		if m := trimPrefix(d, prefix); m != d {
+			d = m
+			break
+		}
+	}
+
+	if !validDOI.MatchString(d) {
+		return "", ErrDOI
+	}
+	return d, nil
+}
@@ -0,0 +1,119 @@
+package identifier
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOCLC(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   string
+		err    error
+	}{
+		{"digits", "12345678", "12345678", nil},
+		{"leading zeros", "00012345", "12345", nil},
+		{"ocm", "ocm12345678", "12345678", nil},
+		{"ocn", "ocn123456789", "123456789", nil},
+		{"on", "on1234567890", "1234567890", nil},
+		{"prefix in capitals", "OCM12345678", "12345678", nil},
+		{"marc prefix", "(OCoLC)12345678", "12345678", nil},
+		{"marc prefix with a space", "(OCoLC) 00012345", "12345", nil},
+		{"marc prefix before ocm", "(OCoLC)ocm12345678", "12345678", nil},
+		{"surrounding blanks", "  ocn 123456789 ", "123456789", nil},
+		{"empty", "", "", ErrOCLC},
+		{"prefix only", "ocm", "", ErrOCLC},
+		{"zeros only", "000", "", ErrOCLC},
+		{"letters", "12a45", "", ErrOCLC},
+		{"unknown prefix", "oclc12345", "", ErrOCLC},
+		{"two prefixes", "ocmocn12345", "", ErrOCLC},
+		{"negative", "-12345", "", ErrOCLC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OCLC(tt.number)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("OCLC(%q) = %q, %v; want %q, %v", tt.number, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestLCCN(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   string
+		err    error
+	}{
+		{"normalized", "n78890351", "n78890351", nil},
+		{"four digit year", "2001000002", "2001000002", nil},
+		{"no prefix", "85000002", "85000002", nil},
+		{"hyphenated serial", "n78-890351", "n78890351", nil},
+		{"short serial is padded", "85-2", "85000002", nil},
+		{"four digit year and short serial", "2001-1", "2001000001", nil},
+		{"blanks", " n 78890351 ", "n78890351", nil},
+		{"capitals", "N78-890351", "n78890351", nil},
+		{"revision suffix", "85-2 /AC/r932", "85000002", nil},
+		{"three letter prefix", "agr25-1", "agr25000001", nil},
+		{"empty", "", "", ErrLCCN},
+		{"empty serial", "85-", "", ErrLCCN},
+		{"empty serial before a suffix", "n78-/r932", "", ErrLCCN},
+		{"empty year", "-890351", "", ErrLCCN},
+		{"serial too long", "85-1234567", "", ErrLCCN},
+		{"letters in the serial", "85-12a", "", ErrLCCN},
+		{"two hyphens", "85-1-2", "", ErrLCCN},
+		{"four letter prefix", "abcd78890351", "", ErrLCCN},
+		{"three digit year", "785-1", "", ErrLCCN},
+		{"nine digits", "788903510", "", ErrLCCN},
+		{"letters after the digits", "78890351n", "", ErrLCCN},
+		{"suffix only", "/r932", "", ErrLCCN},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LCCN(tt.number)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("LCCN(%q) = %q, %v; want %q, %v", tt.number, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestDOI(t *testing.T) {
+	tests := []struct {
+		name string
+		doi  string
+		want string
+		err  error
+	}{
+		{"bare", "10.1000/182", "10.1000/182", nil},
+		{"case is kept", "10.1000/ABC.def", "10.1000/ABC.def", nil},
+		{"doi prefix", "doi:10.1000/182", "10.1000/182", nil},
+		{"doi prefix with a space", "DOI: 10.1000/182", "10.1000/182", nil},
+		{"resolver", "https://doi.org/10.1000/182", "10.1000/182", nil},
+		{"old resolver", "http://dx.doi.org/10.1000/182", "10.1000/182", nil},
+		{"resolver in capitals", "HTTPS://DOI.ORG/10.1000/182", "10.1000/182", nil},
+		{"slashes in the suffix", "10.1002/(SICI)1097-4571/xyz", "10.1002/(SICI)1097-4571/xyz", nil},
+		{"surrounding blanks", " 10.1000/182 ", "10.1000/182", nil},
+		{"empty", "", "", ErrDOI},
+		{"prefix only", "doi:", "", ErrDOI},
+		{"no suffix", "10.1000/", "", ErrDOI},
+		{"no slash", "10.1000", "", ErrDOI},
+		{"registrant too short", "10.123/xyz", "", ErrDOI},
+		{"not a doi directory", "11.1000/182", "", ErrDOI},
+		{"blank in the suffix", "10.1000/a b", "", ErrDOI},
+		{"other resolver", "https://example.com/10.1000/182", "", ErrDOI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DOI(tt.doi)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("DOI(%q) = %q, %v; want %q, %v", tt.doi, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
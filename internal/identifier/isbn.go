@@ -0,0 +1,98 @@
+// Package identifier validates and normalizes the standard identifiers a book
+// can carry: ISBNs, OCLC numbers, LCCNs and DOIs. Every function accepts the
+// forms people commonly type or paste, and returns the one form that's stored
+// and compared, so the same book is always found by the same value.
+package identifier
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors returned for ISBNs that can't be normalized.
+var (
+	ErrISBNFormat   = errors.New("isbn must have 10 or 13 digits, the last of an ISBN-10 may be X")
+	ErrISBNChecksum = errors.New("isbn check digit does not match")
+	ErrISBNPrefix   = errors.New("isbn-13 must start with 978 or 979")
+)
+
+// ISBN13 returns isbn as 13 digits without separators. isbn may be an ISBN-10,
+// which is converted, or an ISBN-13, with hyphens or spaces between groups.
+func ISBN13(isbn string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
+
+	switch len(digits) {
+	case 10:
+		if !numeric(digits[:9]) || !(numeric(digits[9:]) || digits[9] == 'X') {
+			return "", ErrISBNFormat
+		}
+		if check10(digits[:9]) != digits[9] {
+			return "", ErrISBNChecksum
+		}
+		body := "978" + digits[:9]
+		return body + string(check13(body)), nil
+
+	case 13:
+		if !numeric(digits) {
+			return "", ErrISBNFormat
+		}
+		if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return "", ErrISBNPrefix
+		}
+		if check13(digits[:12]) != digits[12] {
+			return "", ErrISBNChecksum
+		}
+		return digits, nil
+	}
+
+	return "", ErrISBNFormat
+}
+
+// ISBN10 converts a normalized ISBN-13 back to an ISBN-10. Only ISBNs starting
+// with 978 have one.
+func ISBN10(isbn13 string) (string, bool) {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") || !numeric(isbn13) {
+		return "", false
+	}
+
+	body := isbn13[3:12]
+	return body + string(check10(body)), true
+}
+
+// check10 computes the ISBN-10 check digit of the first nine digits.
+func check10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+
+	switch check := (11 - sum%11) % 11; check {
+	case 10:
+		return 'X'
+	default:
+		return byte('0' + check)
+	}
+}
+
+// check13 computes the ISBN-13 check digit of the first twelve digits.
+func check13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(body[i]-'0')
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func numeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
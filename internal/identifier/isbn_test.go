@@ -0,0 +1,82 @@
+package identifier
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestISBN13(t *testing.T) {
+	tests := []struct {
+		name string
+		isbn string
+		want string
+		err  error
+	}{
+		{"isbn-13", "9780306406157", "9780306406157", nil},
+		{"isbn-13 with hyphens", "978-0-306-40615-7", "9780306406157", nil},
+		{"isbn-13 with spaces", " 978 0 306 40615 7 ", "9780306406157", nil},
+		{"isbn-13 starting with 979", "979-10-90636-07-1", "9791090636071", nil},
+		{"isbn-10", "0-306-40615-2", "9780306406157", nil},
+		{"isbn-10 ending in X", "0-8044-2957-X", "9780804429573", nil},
+		{"isbn-10 ending in lowercase x", "080442957x", "9780804429573", nil},
+		{"isbn-10 with a check digit of zero", "0-306-40602-0", "9780306406027", nil},
+		{"empty", "", "", ErrISBNFormat},
+		{"too short", "978030640615", "", ErrISBNFormat},
+		{"too long", "97803064061570", "", ErrISBNFormat},
+		{"letters", "97803064O6157", "", ErrISBNFormat},
+		{"X inside an isbn-10", "0X06406152", "", ErrISBNFormat},
+		{"X ending an isbn-13", "978030640615X", "", ErrISBNFormat},
+		{"other separators", "978.0.306.40615.7", "", ErrISBNFormat},
+		{"isbn-13 checksum", "9780306406158", "", ErrISBNChecksum},
+		{"isbn-10 checksum", "0306406153", "", ErrISBNChecksum},
+		{"isbn-10 X where a digit belongs", "030640615X", "", ErrISBNChecksum},
+		{"isbn-13 prefix", "9770306406157", "", ErrISBNPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ISBN13(tt.isbn)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("ISBN13(%q) = %q, %v; want %q, %v", tt.isbn, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestISBN10(t *testing.T) {
+	tests := []struct {
+		name   string
+		isbn13 string
+		want   string
+		ok     bool
+	}{
+		{"978", "9780306406157", "0306406152", true},
+		{"check digit X", "9780804429573", "080442957X", true},
+		{"979 has no isbn-10", "9791090636071", "", false},
+		{"not normalized", "978-0-306-40615-7", "", false},
+		{"too short", "978030640615", "", false},
+		{"empty", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ISBN10(tt.isbn13)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ISBN10(%q) = %q, %v; want %q, %v", tt.isbn13, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// Converting to an ISBN-13 and back gives the ISBN-10 that went in.
+func TestISBN10RoundTrip(t *testing.T) {
+	for _, isbn := range []string{"0306406152", "080442957X", "0306406020", "0000000000"} {
+		isbn13, err := ISBN13(isbn)
+		if err != nil {
+			t.Fatalf("ISBN13(%q): %v", isbn, err)
+		}
+		if got, _ := ISBN10(isbn13); got != isbn {
+			t.Errorf("%q became %q and then %q", isbn, isbn13, got)
+		}
+	}
+}
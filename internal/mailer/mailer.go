@@ -0,0 +1,147 @@
+// Package mailer sends plain text email through an SMTP server, such as the
+// MailHog instance in docker-compose.yml during development.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages from one sender through one SMTP server.
+type Mailer struct {
+	addr   string // host:port
+	host   string
+	auth   smtp.Auth // nil without credentials
+	sender *mail.Address
+}
+
+// New returns a Mailer for the server at rawURL, smtp://[user:password@]host:port,
+// sending as sender, e.g. "GoBook <no-reply@example.com>". Connections are
+// upgraded with STARTTLS when the server offers it; credentials are only sent
+// over TLS, or to localhost.
+func New(rawURL, sender string) (*Mailer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "smtp" || u.Hostname() == "" {
+		return nil, errors.New("smtp url must look like smtp://host:port")
+	}
+
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("sender: %w", err)
+	}
+
+	m := &Mailer{addr: u.Host, host: u.Hostname(), sender: from}
+	if u.Port() == "" {
+		m.addr = net.JoinHostPort(m.host, "25")
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, m.host)
+	}
+
+	return m, nil
+}
+
+// Send delivers msg, giving up when ctx is done.
+func (m *Mailer) Send(ctx context.Context, msg Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp has no context support, so the deadline bounds the conversation
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.sender.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(m.compose(to, msg))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// compose returns msg with its headers, with CRLF line endings.
+func (m *Mailer) compose(to *mail.Address, msg Message) []byte {
+	var b strings.Builder
+	header := func(key, value string) {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+
+	header("From", m.sender.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory. Each instance of the API limits
+// clients on its own, so it suits a single instance deployment.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // the clock, replaced in tests
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    float64
+	burst   float64
+}
+
+// sweepEvery is how often buckets that have refilled completely are dropped.
+const sweepEvery = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > sweepEvery {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.rate, b.burst = limit.Rate, float64(limit.Burst)
+
+	b.tokens = b.level(now)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return result(limit, b.tokens, allowed), nil
+}
+
+// level is the number of tokens in the bucket at now.
+func (b *bucket) level(now time.Time) float64 {
+	return math.Min(b.burst, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+}
+
+// sweep forgets full buckets; a new one starts full anyway.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.level(now) >= b.burst {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a time that only moves when told to.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestStore() (*MemoryStore, *clock) {
+	c := &clock{t: time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = c.now
+	return s, c
+}
+
+func take(t *testing.T, s *MemoryStore, key string, limit Limit) Result {
+	t.Helper()
+	res, err := s.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	s, _ := newTestStore()
+	limit := PerMinute(60, 3) // a token a second, three at once
+
+	for i := 2; i >= 0; i-- {
+		res := take(t, s, "a", limit)
+		if !res.Allowed || res.Remaining != i || res.Limit != 3 {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", 3-i, res, i)
+		}
+	}
+
+	res := take(t, s, "a", limit)
+	if res.Allowed {
+		t.Fatal("a request beyond the burst was allowed")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("got RetryAfter %v, want 1s", res.RetryAfter)
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("got Reset %v, want 3s", res.Reset)
+	}
+
+	// buckets are per key
+	if res := take(t, s, "b", limit); !res.Allowed {
+		t.Error("another key shared the exhausted bucket")
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	s, c := newTestStore()
+	limit := PerMinute(30, 2) // a token every two seconds
+
+	take(t, s, "a", limit)
+	take(t, s, "a", limit)
+	if take(t, s, "a", limit).Allowed {
+		t.Fatal("an empty bucket allowed a request")
+	}
+
+	c.advance(time.Second)
+	if res := take(t, s, "a", limit); res.Allowed {
+		t.Fatalf("half a token allowed a request: %+v", res)
+	}
+
+	c.advance(time.Second)
+	if res := take(t, s, "a", limit); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("a refilled token wasn't taken: %+v", res)
+	}
+
+	// a long wait fills the bucket, but never past the burst
+	c.advance(time.Hour)
+	res := take(t, s, "a", limit)
+	if !res.Allowed || res.Remaining != 1 {
+		t.Errorf("got %+v, want allowed with 1 remaining after refilling to the burst", res)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s, c := newTestStore()
+	limit := PerMinute(60, 1)
+
+	take(t, s, "full", limit)
+	c.advance(2 * sweepEvery)
+	take(t, s, "other", limit)
+
+	if _, ok := s.buckets["full"]; ok {
+		t.Error("a refilled bucket was kept by the sweep")
+	}
+	if _, ok := s.buckets["other"]; !ok {
+		t.Error("the bucket in use was swept")
+	}
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   Limit
+		tokens  float64
+		allowed bool
+		want    Result
+	}{
+		{"full", PerMinute(60, 5), 5, true, Result{Allowed: true, Limit: 5, Remaining: 5}},
+		{"partial tokens round down", PerMinute(60, 5), 2.5, true, Result{Allowed: true, Limit: 5, Remaining: 2, Reset: 2500 * time.Millisecond}},
+		{"refused", PerMinute(60, 5), 0.25, false, Result{Limit: 5, Reset: 4750 * time.Millisecond, RetryAfter: 750 * time.Millisecond}},
+		{"no refill", Limit{Burst: 1}, 0, false, Result{Limit: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := result(tt.limit, tt.tokens, tt.allowed); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
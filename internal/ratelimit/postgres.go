@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PostgresStore keeps buckets in the rate_limits table so every instance of the
+// API shares them. Each Take is a single statement, so concurrent requests for
+// the same key can't both spend the last token.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// pruneAfter is how long an untouched bucket is kept. Any bucket idle that long
+// has refilled for every limit we configure.
+const pruneAfter = time.Hour
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// level is the bucket's tokens now, refilled since it was last touched.
+const level = `least($2::float8, rl.tokens + extract(epoch from now() - rl.updated_at) * $3::float8)`
+
+const takeQuery = `insert into rate_limits as rl (key, tokens, allowed, updated_at)
+	values ($1, $2::float8 - 1, $2::float8 >= 1, now())
+	on conflict (key) do update set
+		tokens = case when ` + level + ` >= 1 then ` + level + ` - 1 else ` + level + ` end,
+		allowed = ` + level + ` >= 1,
+		updated_at = now()
+	returning tokens, allowed`
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	err := s.prune(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens float64
+	var allowed bool
+
+	err = s.db.QueryRowContext(ctx, takeQuery, key, float64(limit.Burst), limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(limit, tokens, allowed), nil
+}
+
+// prune deletes idle buckets, at most once every few minutes per instance.
+func (s *PostgresStore) prune(ctx context.Context) error {
+	s.mu.Lock()
+	due := time.Since(s.lastPrune) > pruneAfter/10
+	if due {
+		s.lastPrune = time.Now()
+	}
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `delete from rate_limits where updated_at < now() - make_interval(secs => $1)`,
+		pruneAfter.Seconds())
+	return err
+}
@@ -0,0 +1,61 @@
+// Package ratelimit throttles clients with token buckets. A bucket holds up to
+// Burst tokens and refills at Rate tokens a second; every request takes one.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes the bucket given to each key.
+type Limit struct {
+	Rate  float64 // tokens added per second
+	Burst int     // bucket size, and so the most requests allowed at once
+}
+
+// PerMinute allows n requests a minute on average, and up to burst at once.
+func PerMinute(n, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed   bool
+	Limit     int           // Burst of the limit applied
+	Remaining int           // whole tokens left
+	Reset     time.Duration // until the bucket is full again
+	// RetryAfter is how long to wait for a token when the request was refused.
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. Take removes a token from the bucket of key, if it
+// has one, and reports what's left.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// result describes a bucket left with tokens after a request was allowed or not.
+func result(limit Limit, tokens float64, allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+	}
+
+	if limit.Rate > 0 {
+		r.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+		if !allowed {
+			r.RetryAfter = seconds((1 - tokens) / limit.Rate)
+		}
+	}
+
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,4 @@
+drop table if exists book_slug_history;
+
+alter table books
+    drop constraint if exists books_slug_key;
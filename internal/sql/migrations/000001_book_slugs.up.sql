@@ -0,0 +1,25 @@
+-- Existing rows may share a slug; suffix every duplicate with its id before
+-- the unique constraint goes on.
+update books b
+set slug = b.slug || '-' || b.id
+where exists(select 1 from books o where o.slug = b.slug and o.id < b.id);
+
+alter table books
+    add constraint books_slug_key unique (slug);
+
+create table book_slug_history
+(
+    id         integer generated always as identity
+        constraint book_slug_history_pkey
+            primary key,
+    book_id    integer                  not null
+        constraint book_slug_history_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    slug       varchar(512)             not null
+        constraint book_slug_history_slug_key
+            unique,
+    created_at timestamp with time zone not null default now()
+);
+
+create index book_slug_history_book_id_idx on book_slug_history (book_id);
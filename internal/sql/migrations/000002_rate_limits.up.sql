@@ -0,0 +1,12 @@
+-- Token buckets shared by every API instance when ratelimit.store is postgres.
+create unlogged table rate_limits
+(
+    key        varchar(255)             not null
+        constraint rate_limits_pkey
+            primary key,
+    tokens     double precision         not null,
+    allowed    boolean                  not null,
+    updated_at timestamp with time zone not null default now()
+);
+
+create index rate_limits_updated_at_idx on rate_limits (updated_at);
@@ -0,0 +1,7 @@
+-- Every update bumps version, so an edit can require the version it was based
+-- on and fail instead of overwriting a change made in the meantime.
+alter table books
+    add column version integer not null default 1;
+
+alter table users
+    add column version integer not null default 1;
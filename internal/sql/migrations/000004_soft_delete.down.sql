@@ -0,0 +1,14 @@
+-- Anything still in the trash is deleted for good, as it would have been
+-- before soft deletion.
+delete from books where deleted_at is not null;
+delete from authors where deleted_at is not null;
+delete from users where deleted_at is not null;
+
+alter table users
+    drop column if exists deleted_at;
+
+alter table authors
+    drop column if exists deleted_at;
+
+alter table books
+    drop column if exists deleted_at;
@@ -0,0 +1,15 @@
+-- Deleted books, authors and users are kept, with deleted_at set, until the
+-- trash is purged. Listings only ever look at live rows, hence the partial
+-- indexes; the purge job looks up trashed rows by when they were deleted.
+alter table books
+    add column deleted_at timestamp with time zone;
+
+alter table authors
+    add column deleted_at timestamp with time zone;
+
+alter table users
+    add column deleted_at timestamp with time zone;
+
+create index books_deleted_at_idx on books (deleted_at) where deleted_at is not null;
+create index authors_deleted_at_idx on authors (deleted_at) where deleted_at is not null;
+create index users_deleted_at_idx on users (deleted_at) where deleted_at is not null;
@@ -0,0 +1,4 @@
+drop table if exists book_revisions;
+
+alter table books
+    drop column if exists cover_key;
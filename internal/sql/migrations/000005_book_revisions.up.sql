@@ -0,0 +1,37 @@
+-- Every save of a book is recorded as a revision, a copy of its fields at that
+-- version, so changes can be compared and reverted. cover_key identifies the
+-- cover image, which is kept under that key for as long as revisions use it.
+alter table books
+    add column cover_key varchar(64) not null default '';
+
+create table book_revisions
+(
+    id               integer generated always as identity
+        constraint book_revisions_pkey
+            primary key,
+    book_id          integer                  not null
+        constraint book_revisions_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    version          integer                  not null,
+    title            varchar(512)             not null,
+    author_id        integer                  not null,
+    publication_year integer                  not null,
+    description      text                     not null,
+    genre_ids        integer[]                not null default '{}',
+    cover_key        varchar(64)              not null default '',
+    editor_id        integer
+        constraint book_revisions_editor_id_fkey
+            references users
+            on update cascade on delete set null,
+    created_at       timestamp with time zone not null default now(),
+    constraint book_revisions_book_id_version_key
+        unique (book_id, version)
+);
+
+-- existing books start their history at the version they're at now
+insert into book_revisions (book_id, version, title, author_id, publication_year, description, genre_ids, created_at)
+select b.id, b.version, b.title, b.author_id, b.publication_year, coalesce(b.description, ''),
+       coalesce((select array_agg(g.genre_id order by g.genre_id) from books_genres g where g.book_id = b.id), '{}'),
+       coalesce(b.updated_at, now())
+from books b;
@@ -0,0 +1,4 @@
+alter table book_revisions
+    drop column if exists contributors;
+
+drop table if exists book_contributors;
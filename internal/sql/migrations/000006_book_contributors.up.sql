@@ -0,0 +1,32 @@
+-- A book can have any number of contributors, each in a role, listed in order.
+-- books.author_id stays as the first author, for clients that only know one.
+create table book_contributors
+(
+    book_id    integer                  not null
+        constraint book_contributors_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    author_id  integer                  not null
+        constraint book_contributors_author_id_fkey
+            references authors
+            on update cascade on delete cascade,
+    role       varchar(32)              not null
+        constraint book_contributors_role_check
+            check (role in ('author', 'editor', 'translator', 'illustrator')),
+    position   integer                  not null,
+    created_at timestamp with time zone not null default now(),
+    constraint book_contributors_pkey
+        primary key (book_id, author_id, role)
+);
+
+create index book_contributors_author_id_idx on book_contributors (author_id);
+
+insert into book_contributors (book_id, author_id, role, position)
+select id, author_id, 'author', 1
+from books;
+
+alter table book_revisions
+    add column contributors jsonb not null default '[]';
+
+update book_revisions
+set contributors = jsonb_build_array(jsonb_build_object('author_id', author_id, 'role', 'author', 'position', 1));
@@ -0,0 +1,11 @@
+alter table book_revisions
+    drop column if exists doi,
+    drop column if exists lccn,
+    drop column if exists oclc,
+    drop column if exists isbn13;
+
+alter table books
+    drop column if exists doi,
+    drop column if exists lccn,
+    drop column if exists oclc,
+    drop column if exists isbn13;
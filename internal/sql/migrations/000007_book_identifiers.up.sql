@@ -0,0 +1,18 @@
+-- Standard identifiers, stored normalized (see internal/identifier) so each
+-- one names at most one book. ISBN-10s are converted to ISBN-13 on the way in.
+alter table books
+    add column isbn13 varchar(13),
+    add column oclc   varchar(32),
+    add column lccn   varchar(32),
+    add column doi    varchar(255);
+
+create unique index books_isbn13_key on books (isbn13) where isbn13 is not null;
+create unique index books_oclc_key on books (oclc) where oclc is not null;
+create unique index books_lccn_key on books (lccn) where lccn is not null;
+create unique index books_doi_key on books (lower(doi)) where doi is not null;
+
+alter table book_revisions
+    add column isbn13 varchar(13)  not null default '',
+    add column oclc   varchar(32)  not null default '',
+    add column lccn   varchar(32)  not null default '',
+    add column doi    varchar(255) not null default '';
@@ -0,0 +1,3 @@
+drop table if exists book_series;
+
+drop table if exists series;
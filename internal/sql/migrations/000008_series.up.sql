@@ -0,0 +1,47 @@
+-- A series is an ordered run of books. Positions are numbers rather than
+-- integers so novellas can sit between two books, e.g. 4.5.
+create table series
+(
+    id          integer generated always as identity
+        constraint series_pkey
+            primary key,
+    name        varchar(255)             not null,
+    slug        varchar(255)             not null
+        constraint series_slug_key
+            unique,
+    description text                     not null default '',
+    created_at  timestamp with time zone not null default now(),
+    updated_at  timestamp with time zone not null default now()
+);
+
+create table book_series
+(
+    series_id  integer                  not null
+        constraint book_series_series_id_fkey
+            references series
+            on update cascade on delete cascade,
+    book_id    integer                  not null
+        constraint book_series_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    position   numeric(6, 2)            not null
+        constraint book_series_position_check
+            check (position >= 0),
+    created_at timestamp with time zone not null default now(),
+    constraint book_series_pkey
+        primary key (series_id, book_id),
+    constraint book_series_position_key
+        unique (series_id, position)
+);
+
+create index book_series_book_id_idx on book_series (book_id);
+
+-- the seed data opens one series
+insert into series (name, slug, description)
+select 'The Dark Tower', 'the-dark-tower', 'Roland Deschain''s quest for the Dark Tower.'
+where exists(select 1 from books where slug = 'the-gunslinger');
+
+insert into book_series (series_id, book_id, position)
+select s.id, b.id, 1
+from series s, books b
+where s.slug = 'the-dark-tower' and b.slug = 'the-gunslinger';
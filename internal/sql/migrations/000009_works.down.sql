@@ -0,0 +1,15 @@
+alter table book_revisions
+    drop column if exists language,
+    drop column if exists page_count,
+    drop column if exists publisher,
+    drop column if exists format,
+    drop column if exists work_id;
+
+alter table books
+    drop column if exists language,
+    drop column if exists page_count,
+    drop column if exists publisher,
+    drop column if exists format,
+    drop column if exists work_id;
+
+drop table if exists works;
@@ -0,0 +1,56 @@
+-- A work is a book as written, and each row of books is now one edition of a
+-- work, with its own format, publisher, page count, language, ISBN and cover.
+-- Existing books each become the only edition of a work of the same title.
+create table works
+(
+    id         integer generated always as identity
+        constraint works_pkey
+            primary key,
+    title      varchar(512)             not null,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now(),
+    book_id    integer -- only used while backfilling
+);
+
+insert into works (title, created_at, updated_at, book_id)
+select title, coalesce(created_at, now()), coalesce(updated_at, now()), id
+from books;
+
+alter table books
+    add column work_id    integer
+        constraint books_work_id_fkey
+            references works
+            on update cascade,
+    add column format     varchar(32)  not null default ''
+        constraint books_format_check
+            check (format in ('', 'hardcover', 'paperback', 'ebook', 'audiobook')),
+    add column publisher  varchar(255) not null default '',
+    add column page_count integer      not null default 0
+        constraint books_page_count_check
+            check (page_count >= 0),
+    add column language   varchar(35)  not null default '';
+
+update books b
+set work_id = w.id
+from works w
+where w.book_id = b.id;
+
+alter table books
+    alter column work_id set not null;
+
+alter table works
+    drop column book_id;
+
+create index books_work_id_idx on books (work_id);
+
+alter table book_revisions
+    add column work_id    integer,
+    add column format     varchar(32)  not null default '',
+    add column publisher  varchar(255) not null default '',
+    add column page_count integer      not null default 0,
+    add column language   varchar(35)  not null default '';
+
+update book_revisions r
+set work_id = b.work_id
+from books b
+where b.id = r.book_id;
@@ -0,0 +1,27 @@
+alter table book_revisions
+    add column publisher varchar(255) not null default '';
+
+update book_revisions r
+set publisher = p.name
+from publishers p
+where p.id = r.publisher_id;
+
+alter table book_revisions
+    drop column if exists imprint_id,
+    drop column if exists publisher_id;
+
+alter table books
+    add column publisher varchar(255) not null default '';
+
+update books b
+set publisher = p.name
+from publishers p
+where p.id = b.publisher_id;
+
+alter table books
+    drop column if exists imprint_id,
+    drop column if exists publisher_id;
+
+drop table if exists imprints;
+
+drop table if exists publishers;
@@ -0,0 +1,70 @@
+-- Publishers, and the imprints they publish under, replace the free text
+-- publisher of each edition. Existing names, from books and their revisions,
+-- become publishers.
+create table publishers
+(
+    id         integer generated always as identity
+        constraint publishers_pkey
+            primary key,
+    name       varchar(255)             not null,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+);
+
+create unique index publishers_name_key on publishers (lower(name));
+
+create table imprints
+(
+    id           integer generated always as identity
+        constraint imprints_pkey
+            primary key,
+    publisher_id integer                  not null
+        constraint imprints_publisher_id_fkey
+            references publishers
+            on update cascade on delete cascade,
+    name         varchar(255)             not null,
+    created_at   timestamp with time zone not null default now(),
+    updated_at   timestamp with time zone not null default now()
+);
+
+create unique index imprints_publisher_id_name_key on imprints (publisher_id, lower(name));
+
+insert into publishers (name)
+select min(name)
+from (select publisher as name from books
+      union all
+      select publisher from book_revisions) names
+where name <> ''
+group by lower(name);
+
+alter table books
+    add column publisher_id integer
+        constraint books_publisher_id_fkey
+            references publishers
+            on update cascade,
+    add column imprint_id   integer
+        constraint books_imprint_id_fkey
+            references imprints
+            on update cascade;
+
+create index books_publisher_id_idx on books (publisher_id);
+
+update books b
+set publisher_id = p.id
+from publishers p
+where lower(p.name) = lower(b.publisher);
+
+alter table books
+    drop column publisher;
+
+alter table book_revisions
+    add column publisher_id integer,
+    add column imprint_id   integer;
+
+update book_revisions r
+set publisher_id = p.id
+from publishers p
+where lower(p.name) = lower(r.publisher);
+
+alter table book_revisions
+    drop column publisher;
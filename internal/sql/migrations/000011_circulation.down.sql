@@ -0,0 +1,3 @@
+drop table if exists loans;
+
+drop table if exists copies;
@@ -0,0 +1,50 @@
+-- Physical copies of books and the loans of them to users. A copy is on loan
+-- while it has a loan that hasn't been returned; there's at most one.
+create table copies
+(
+    id         integer generated always as identity
+        constraint copies_pkey
+            primary key,
+    book_id    integer                  not null
+        constraint copies_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    barcode    varchar(64)              not null
+        constraint copies_barcode_key
+            unique,
+    location   varchar(255)             not null default '',
+    condition  varchar(16)              not null default 'good'
+        constraint copies_condition_check
+            check (condition in ('new', 'good', 'fair', 'poor', 'damaged')),
+    status     varchar(16)              not null default 'available'
+        constraint copies_status_check
+            check (status in ('available', 'on_loan', 'lost', 'withdrawn')),
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+);
+
+create index copies_book_id_idx on copies (book_id);
+
+create table loans
+(
+    id             integer generated always as identity
+        constraint loans_pkey
+            primary key,
+    copy_id        integer                  not null
+        constraint loans_copy_id_fkey
+            references copies
+            on update cascade on delete cascade,
+    user_id        integer                  not null
+        constraint loans_user_id_fkey
+            references users
+            on update cascade on delete cascade,
+    checked_out_at timestamp with time zone not null default now(),
+    due_at         timestamp with time zone not null,
+    returned_at    timestamp with time zone,
+    renewals       integer                  not null default 0,
+    created_at     timestamp with time zone not null default now(),
+    updated_at     timestamp with time zone not null default now()
+);
+
+create unique index loans_copy_id_open_key on loans (copy_id) where returned_at is null;
+create index loans_user_id_idx on loans (user_id);
@@ -0,0 +1,8 @@
+drop table if exists holds;
+
+update copies set status = 'available' where status = 'on_hold';
+
+alter table copies
+    drop constraint copies_status_check,
+    add constraint copies_status_check
+        check (status in ('available', 'on_loan', 'lost', 'withdrawn'));
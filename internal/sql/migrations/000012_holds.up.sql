@@ -0,0 +1,39 @@
+-- Holds queue users for a book whose copies are all out. When a copy comes
+-- back it's put on hold for the first waiting user, who has until expires_at
+-- to check it out.
+alter table copies
+    drop constraint copies_status_check,
+    add constraint copies_status_check
+        check (status in ('available', 'on_loan', 'on_hold', 'lost', 'withdrawn'));
+
+create table holds
+(
+    id         integer generated always as identity
+        constraint holds_pkey
+            primary key,
+    book_id    integer                  not null
+        constraint holds_book_id_fkey
+            references books
+            on update cascade on delete cascade,
+    user_id    integer                  not null
+        constraint holds_user_id_fkey
+            references users
+            on update cascade on delete cascade,
+    status     varchar(16)              not null default 'waiting'
+        constraint holds_status_check
+            check (status in ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+    copy_id    integer
+        constraint holds_copy_id_fkey
+            references copies
+            on update cascade on delete set null,
+    ready_at   timestamp with time zone,
+    expires_at timestamp with time zone,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+);
+
+-- a user holds a book once at a time, and a copy is held for one user
+create unique index holds_book_id_user_id_active_key on holds (book_id, user_id) where status in ('waiting', 'ready');
+create unique index holds_copy_id_ready_key on holds (copy_id) where status = 'ready';
+create index holds_book_id_queue_idx on holds (book_id, created_at, id) where status = 'waiting';
+create index holds_user_id_idx on holds (user_id);
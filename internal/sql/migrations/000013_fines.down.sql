@@ -0,0 +1,6 @@
+drop table if exists ledger_entries;
+
+drop index if exists loans_open_due_at_idx;
+
+alter table loans
+    drop column if exists overdue_at;
@@ -0,0 +1,39 @@
+-- Overdue loans are flagged once and accrue a fine, one ledger entry per loan
+-- that grows until the copy is returned. Payments and waivers are recorded
+-- against the same ledger; a user's balance is the sum of their entries.
+alter table loans
+    add column overdue_at timestamp with time zone;
+
+create index loans_open_due_at_idx on loans (due_at) where returned_at is null;
+
+create table ledger_entries
+(
+    id          integer generated always as identity
+        constraint ledger_entries_pkey
+            primary key,
+    user_id     integer                  not null
+        constraint ledger_entries_user_id_fkey
+            references users
+            on update cascade on delete cascade,
+    loan_id     integer
+        constraint ledger_entries_loan_id_fkey
+            references loans
+            on update cascade on delete set null,
+    kind        varchar(16)              not null
+        constraint ledger_entries_kind_check
+            check (kind in ('fine', 'payment', 'waiver')),
+    -- in cents; fines add to the balance, payments and waivers take from it
+    amount      integer                  not null
+        constraint ledger_entries_amount_check
+            check ((kind = 'fine' and amount >= 0) or (kind <> 'fine' and amount < 0)),
+    note        text                     not null default '',
+    recorded_by integer
+        constraint ledger_entries_recorded_by_fkey
+            references users
+            on update cascade on delete set null,
+    created_at  timestamp with time zone not null default now(),
+    updated_at  timestamp with time zone not null default now()
+);
+
+create unique index ledger_entries_loan_id_fine_key on ledger_entries (loan_id) where kind = 'fine';
+create index ledger_entries_user_id_idx on ledger_entries (user_id);
@@ -0,0 +1,5 @@
+-- Staff act on behalf of other users, such as placing holds for them or taking
+-- payments of their fines. Nobody is staff until granted in the database:
+--   update users set staff = true where email = '...';
+alter table users
+    add column staff boolean not null default false;
@@ -0,0 +1,6 @@
+-- Fails while a trashed user shares an email address with another user; purge
+-- the trash or delete one of them first.
+drop index if exists users_email_live_key;
+
+alter table users
+    add constraint users_email_key unique (email);
@@ -0,0 +1,7 @@
+-- Only live users need distinct email addresses, so someone whose account is
+-- in the trash can sign up again. Restoring the old account fails while
+-- another user has its address.
+alter table users
+    drop constraint if exists users_email_key;
+
+create unique index users_email_live_key on users (email) where deleted_at is null;
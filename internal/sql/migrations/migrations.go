@@ -0,0 +1,41 @@
+// Package migrations embeds the schema migrations applied by `make migrate`, so
+// the API can tell whether the database it talks to is up to date.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration, e.g. 1 for
+// 000001_book_slugs.up.sql.
+func Latest() (int64, error) {
+	entries, err := FS.ReadDir(".")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", name, err)
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
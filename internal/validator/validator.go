@@ -0,0 +1,210 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EmailRX is the pattern used by the email rule.
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// SlugRX is the pattern used by the slug rule.
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// LanguageRX is the pattern used by the language rule, the shape of a BCP 47
+// language tag such as en, pt-BR or zh-Hant.
+var LanguageRX = regexp.MustCompile("^[a-zA-Z]{2,3}(?:-[a-zA-Z0-9]{2,8})*$")
+
+// Validator collects field errors, keyed by the JSON name of the field, so that
+// every problem with a request can be reported at once.
+type Validator struct {
+	Errors map[string][]string
+}
+
+func New() *Validator {
+	return &Validator{Errors: make(map[string][]string)}
+}
+
+// Valid reports whether no errors have been recorded.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError records message against field.
+func (v *Validator) AddError(field, message string) {
+	v.Errors[field] = append(v.Errors[field], message)
+}
+
+// Check records message against field unless ok is true.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+// Struct checks each field of s, a struct or a pointer to one, against the
+// comma separated rules in its `validate` tag:
+//
+//	required     the value must not be zero (or blank, for strings)
+//	email        the string must look like an email address
+//	slug         the string must be lowercase words joined by hyphens
+//	language     the string must look like a language tag, e.g. en or pt-BR
+//	min=n, max=n bounds on a number's value, or on the length of a string or slice
+//	oneof=a b c  the value must be one of the space separated options
+//	unique       the slice must not contain duplicates
+//
+// Rules other than required are skipped for zero values, so optional fields
+// only need to be valid when they're supplied. Embedded structs are checked as
+// if their fields belonged to s.
+func (v *Validator) Struct(s any) {
+	v.structValue(reflect.Indirect(reflect.ValueOf(s)))
+}
+
+func (v *Validator) structValue(rv reflect.Value) {
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			v.structValue(rv.Field(i))
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ","); jsonName != "" && jsonName != "-" {
+			name = jsonName
+		}
+
+		value := rv.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+
+			if rule == "required" {
+				if isBlank(value) {
+					v.AddError(name, "must be provided")
+					break
+				}
+				continue
+			}
+
+			if value.IsZero() {
+				break
+			}
+
+			if message := check(rule, arg, value); message != "" {
+				v.AddError(name, message)
+			}
+		}
+	}
+}
+
+// check applies a single rule to value, returning a message when it fails.
+func check(rule, arg string, value reflect.Value) string {
+	switch rule {
+	case "email":
+		if !EmailRX.MatchString(value.String()) {
+			return "must be a valid email address"
+		}
+	case "language":
+		if !LanguageRX.MatchString(value.String()) {
+			return "must be a language tag such as en or pt-BR"
+		}
+	case "slug":
+		if !SlugRX.MatchString(value.String()) {
+			return "must be lowercase letters and digits, with words joined by hyphens"
+		}
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validator: bad %s argument %q", rule, arg))
+		}
+
+		size, unit := measure(value)
+		if (rule == "min" && size < limit) || (rule == "max" && size > limit) {
+			bound := "at least"
+			if rule == "max" {
+				bound = "at most"
+			}
+			if unit == "" {
+				return fmt.Sprintf("must be %s %d", bound, limit)
+			}
+			return fmt.Sprintf("must be %s %d %s", bound, limit, unit)
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		got := fmt.Sprint(scalar(value))
+		for _, option := range options {
+			if got == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(options, ", "))
+	case "unique":
+		seen := make(map[any]bool, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			item := scalar(value.Index(i))
+			if seen[item] {
+				return "must not contain duplicate values"
+			}
+			seen[item] = true
+		}
+	default:
+		panic(fmt.Sprintf("validator: unknown rule %q", rule))
+	}
+
+	return ""
+}
+
+// measure returns the size the min and max rules compare against, and the unit
+// used to describe it.
+func measure(value reflect.Value) (int, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String()), "characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len(), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return int(value.Float()), ""
+	}
+
+	panic(fmt.Sprintf("validator: can't measure %s", value.Kind()))
+}
+
+// scalar returns the data held by value. It avoids value.Interface, which panics
+// for fields promoted from unexported embedded structs.
+func scalar(value reflect.Value) any {
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint()
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+
+	panic(fmt.Sprintf("validator: can't compare %s", value.Kind()))
+}
+
+func isBlank(value reflect.Value) bool {
+	if value.Kind() == reflect.String {
+		return strings.TrimSpace(value.String()) == ""
+	}
+	return value.IsZero()
+}
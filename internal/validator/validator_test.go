@@ -0,0 +1,125 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStruct(t *testing.T) {
+	type input struct {
+		Name     string   `json:"name" validate:"required,max=5"`
+		Email    string   `json:"email" validate:"email"`
+		Slug     string   `json:"slug" validate:"slug"`
+		Language string   `json:"language" validate:"language"`
+		Year     int      `json:"year" validate:"min=1,max=2100"`
+		Format   string   `json:"format" validate:"oneof=hardcover paperback"`
+		Active   int      `json:"active" validate:"oneof=0 1"`
+		Tags     []string `json:"tags" validate:"max=2,unique"`
+		IDs      []int    `json:"ids" validate:"unique"`
+		Password string   `json:"password" validate:"min=8"`
+		Internal string   `validate:"required"`
+		Ignored  string   `json:"ignored"`
+	}
+	valid := input{Name: "Dune", Internal: "x"}
+
+	tests := []struct {
+		name  string
+		edit  func(*input)
+		field string // the only field expected to fail, empty when valid
+		want  string
+	}{
+		{"zero values are optional", func(*input) {}, "", ""},
+		{"required missing", func(in *input) { in.Name = "" }, "name", "must be provided"},
+		{"required blank", func(in *input) { in.Name = "   " }, "name", "must be provided"},
+		{"required without json name", func(in *input) { in.Internal = "" }, "Internal", "must be provided"},
+		{"max string length", func(in *input) { in.Name = "Dunes!" }, "name", "must be at most 5 characters long"},
+		{"max counts runes", func(in *input) { in.Name = "Ñandú" }, "", ""},
+		{"email", func(in *input) { in.Email = "ada@example.com" }, "", ""},
+		{"email invalid", func(in *input) { in.Email = "ada@" }, "email", "must be a valid email address"},
+		{"slug", func(in *input) { in.Slug = "the-left-hand-2" }, "", ""},
+		{"slug invalid", func(in *input) { in.Slug = "The--Left" }, "slug", "must be lowercase letters and digits, with words joined by hyphens"},
+		{"language", func(in *input) { in.Language = "pt-BR" }, "", ""},
+		{"language script", func(in *input) { in.Language = "zh-Hant" }, "", ""},
+		{"language invalid", func(in *input) { in.Language = "english!" }, "language", "must be a language tag such as en or pt-BR"},
+		{"min number", func(in *input) { in.Year = -3 }, "year", "must be at least 1"},
+		{"max number", func(in *input) { in.Year = 2101 }, "year", "must be at most 2100"},
+		{"number in range", func(in *input) { in.Year = 1965 }, "", ""},
+		{"min string length", func(in *input) { in.Password = "short" }, "password", "must be at least 8 characters long"},
+		{"oneof", func(in *input) { in.Format = "paperback" }, "", ""},
+		{"oneof invalid", func(in *input) { in.Format = "scroll" }, "format", "must be one of hardcover, paperback"},
+		{"oneof number", func(in *input) { in.Active = 1 }, "", ""},
+		{"oneof number invalid", func(in *input) { in.Active = 2 }, "active", "must be one of 0, 1"},
+		{"max items", func(in *input) { in.Tags = []string{"a", "b", "c"} }, "tags", "must be at most 2 items"},
+		{"unique strings", func(in *input) { in.Tags = []string{"a", "a"} }, "tags", "must not contain duplicate values"},
+		{"unique numbers", func(in *input) { in.IDs = []int{1, 2, 1} }, "ids", "must not contain duplicate values"},
+		{"unique numbers distinct", func(in *input) { in.IDs = []int{1, 2, 3} }, "", ""},
+		{"untagged fields are ignored", func(in *input) { in.Ignored = "anything" }, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := valid
+			tt.edit(&in)
+
+			v := New()
+			v.Struct(&in)
+
+			want := map[string][]string{}
+			if tt.field != "" {
+				want[tt.field] = []string{tt.want}
+			}
+			if !reflect.DeepEqual(v.Errors, want) {
+				t.Errorf("got %v, want %v", v.Errors, want)
+			}
+		})
+	}
+}
+
+func TestStructEmbedded(t *testing.T) {
+	type base struct {
+		Title string `json:"title" validate:"required"`
+	}
+	var in struct {
+		ID int `json:"id" validate:"min=1"`
+		base
+	}
+	in.ID = -1
+
+	v := New()
+	v.Struct(in)
+
+	want := map[string][]string{"id": {"must be at least 1"}, "title": {"must be provided"}}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got %v, want %v", v.Errors, want)
+	}
+}
+
+func TestStructUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unknown rule didn't panic")
+		}
+	}()
+
+	in := struct {
+		Name string `validate:"shiny"`
+	}{Name: "x"}
+	New().Struct(&in)
+}
+
+func TestCheck(t *testing.T) {
+	v := New()
+	v.Check(true, "a", "never")
+	if !v.Valid() {
+		t.Fatalf("passing check recorded %v", v.Errors)
+	}
+
+	v.Check(false, "a", "first")
+	v.Check(false, "a", "second")
+	if v.Valid() {
+		t.Fatal("failing checks left the validator valid")
+	}
+	if got := v.Errors["a"]; !reflect.DeepEqual(got, []string{"first", "second"}) {
+		t.Errorf("got %q, want both messages in order", got)
+	}
+}